@@ -0,0 +1,253 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// known log formats, apache and nginx share the same common and combined
+var accessLogFormats = map[string]string{
+	"common":   `$remote_addr - $remote_user [$time_local] "$request" $status $body_bytes_sent`,
+	"combined": `$remote_addr - $remote_user [$time_local] "$request" $status $body_bytes_sent "$http_referer" "$http_user_agent"`,
+}
+
+var accessLogVariableRegex = regexp.MustCompile(`\$\{?([a-zA-Z0-9_]+)\}?`)
+
+type accessLogParser struct {
+	regex     *regexp.Regexp
+	variables []string
+}
+
+// newAccessLogParser takes a known format name or a nginx log_format string
+// and builds the parser able to extract its variables from each line
+func newAccessLogParser(format string) (*accessLogParser, error) {
+	if known, ok := accessLogFormats[strings.ToLower(strings.TrimSpace(format))]; ok {
+		format = known
+	}
+
+	// nginx allows the format to be quoted and split in multiple strings
+	format = strings.TrimSpace(format)
+	format = strings.TrimSuffix(format, ";")
+	if strings.Index(format, "'") == 0 && strings.LastIndex(format, "'") == len(format)-1 {
+		format = strings.ReplaceAll(format[1:len(format)-1], "' '", "")
+	}
+
+	if len(format) == 0 {
+		return nil, errors.New("log format is required")
+	}
+
+	parser := &accessLogParser{variables: []string{}}
+
+	// every variable becomes a group, the rest of the format has to match literally
+	pattern := "^"
+	lastIndex := 0
+	for _, match := range accessLogVariableRegex.FindAllStringSubmatchIndex(format, -1) {
+		pattern += regexp.QuoteMeta(format[lastIndex:match[0]]) + "(.*?)"
+		parser.variables = append(parser.variables, format[match[2]:match[3]])
+		lastIndex = match[1]
+	}
+	pattern += regexp.QuoteMeta(format[lastIndex:]) + "$"
+
+	if len(parser.variables) == 0 {
+		return nil, errors.New("log format has no variables")
+	}
+
+	regex, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	parser.regex = regex
+
+	return parser, nil
+}
+
+// headerNameFromVariable converts a nginx header variable to its header name
+// http_user_agent -> User-Agent
+func headerNameFromVariable(variable string) string {
+	parts := strings.Split(strings.TrimPrefix(variable, "http_"), "_")
+	for i, p := range parts {
+		if len(p) > 0 {
+			parts[i] = strings.ToUpper(p[:1]) + p[1:]
+		}
+	}
+
+	return strings.Join(parts, "-")
+}
+
+// parseLine extracts a source from a log line, returns false if the line
+// doesn't match the format
+func (p *accessLogParser) parseLine(line string) (source, bool, error) {
+	newSource := source{RequestHeaders: make(map[string]interface{})}
+
+	match := p.regex.FindStringSubmatch(line)
+	if match == nil {
+		return newSource, false, nil
+	}
+
+	uri := ""
+	path := ""
+	args := ""
+
+	for i, variable := range p.variables {
+		value := match[i+1]
+		// nginx logs empty values as "-"
+		if len(value) == 0 || value == "-" {
+			continue
+		}
+
+		switch variable {
+		case "request":
+			// "GET /path?query HTTP/1.1"
+			parts := strings.Split(value, " ")
+			if len(parts) < 2 {
+				return newSource, false, nil
+			}
+
+			newSource.RequestMethod = parts[0]
+			uri = parts[1]
+			break
+		case "request_method":
+			newSource.RequestMethod = value
+			break
+		case "request_uri":
+			uri = value
+			break
+		case "uri", "document_uri":
+			path = value
+			break
+		case "args", "query_string":
+			args = value
+			break
+		case "host":
+			newSource.RequestHeaders["Host"] = value
+			break
+		case "time_local":
+			t, err := time.Parse("02/Jan/2006:15:04:05 -0700", value)
+			if err != nil {
+				return newSource, false, err
+			}
+
+			newSource.UnixMs = t.UnixMilli()
+			break
+		case "time_iso8601":
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return newSource, false, err
+			}
+
+			newSource.UnixMs = t.UnixMilli()
+			break
+		case "msec":
+			msec, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return newSource, false, err
+			}
+
+			// seconds with the milliseconds as the fraction
+			newSource.UnixMs = int64(msec * 1000)
+			break
+		default:
+			if strings.Index(variable, "http_") == 0 {
+				addSourceHeader(newSource.RequestHeaders, headerNameFromVariable(variable), value)
+			}
+		}
+	}
+
+	// the request uri already has the query, if not we need to build it
+	if len(uri) == 0 {
+		uri = path
+		if len(args) > 0 {
+			uri += "?" + args
+		}
+	}
+	newSource.RequestUrl = uri
+
+	return newSource, len(newSource.RequestUrl) > 0, nil
+}
+
+// firstLine returns the first line that isn't empty
+func firstLine(raw string) string {
+	for _, line := range strings.Split(raw, "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) > 0 {
+			return line
+		}
+	}
+
+	return ""
+}
+
+// detectAccessLogParser returns the parser of the known format the line is
+// on, combined first with a fallback to common, nil when none matches
+func detectAccessLogParser(line string) *accessLogParser {
+	for _, f := range []string{"combined", "common"} {
+		parser, err := newAccessLogParser(f)
+		if err == nil && parser.regex.MatchString(line) {
+			return parser
+		}
+	}
+
+	return nil
+}
+
+// isAccessLogSource checks if a line of the source is on a known format, the
+// lines that can't be parsed are skipped so it doesn't have to be the first
+func isAccessLogSource(raw string) bool {
+	for _, line := range strings.Split(raw, "\n") {
+		if detectAccessLogParser(strings.TrimSpace(line)) != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// convertAccessLogSource takes nginx or apache access logs and writes their
+// records, the format provided is used as it is, otherwise it is found by the
+// first line on a known format, the lines that can't be parsed are skipped
+// and counted
+func convertAccessLogSource(r io.Reader, format string, w *recordWriter) error {
+	var parser *accessLogParser
+	if len(format) > 0 {
+		var err error
+		parser, err = newAccessLogParser(format)
+		if err != nil {
+			return err
+		}
+	}
+	skipped := 0
+
+	err := readLines(r, func(line string) error {
+		if len(strings.TrimSpace(line)) == 0 {
+			return nil
+		}
+
+		if parser == nil {
+			parser = detectAccessLogParser(strings.TrimSpace(line))
+			if parser == nil {
+				skipped += 1
+				return nil
+			}
+		}
+
+		// a line with a bad value doesn't stop the rest of the file
+		newSource, ok, err := parser.parseLine(line)
+		if err != nil || !ok {
+			skipped += 1
+			return nil
+		}
+
+		return w.write(sanitizeSources([]source{newSource}))
+	})
+
+	if skipped > 0 {
+		log.Println("skipped", skipped, "access log lines that could not be parsed")
+	}
+
+	return err
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseAccessLogSkipsBadFirstLine(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "access.log")
+	logLines := []string{
+		"this line was cut by the log rotation",
+		`10.0.0.1 - - [01/Oct/2023:10:00:00 +0000] "GET /users?page=1 HTTP/1.1" 200 12 "-" "curl/8.0"`,
+		`10.0.0.2 - - [01/Oct/2023:10:00:01 +0000] "POST /users/login HTTP/1.1" 200 34 "-" "curl/8.0"`,
+	}
+	if err := os.WriteFile(logPath, []byte(strings.Join(logLines, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, format := range []string{"", "combined"} {
+		t.Run("format "+format, func(t *testing.T) {
+			outputPath := filepath.Join(dir, "records_"+format)
+			if err := parse(logPath, outputPath, format, ""); err != nil {
+				t.Fatal(err)
+			}
+
+			urls := []string{}
+			paths, err := inputPaths(outputPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = readRecords(paths, func(s source) error {
+				urls = append(urls, s.RequestMethod+" "+sourceRequestUrl(s))
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if strings.Join(urls, ", ") != "GET /users?page=1, POST /users/login" {
+				t.Errorf("got %v", urls)
+			}
+		})
+	}
+}
@@ -0,0 +1,299 @@
+package capture
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+)
+
+// Body encodings of the records, an empty encoding is json so the records
+// written before the encoding existed are still read the same
+const (
+	BodyJson      = "json"
+	BodyText      = "text"
+	BodyForm      = "form"
+	BodyMultipart = "multipart"
+	BodyBase64    = "base64"
+)
+
+// BodyPart is a part of a multipart body, the content is kept on Value when
+// it is text and as base64 on Data when it isn't
+type BodyPart struct {
+	Name        string `json:"name"`
+	Filename    string `json:"filename,omitempty"`
+	ContentType string `json:"contentType,omitempty"`
+	Value       string `json:"value,omitempty"`
+	Data        string `json:"data,omitempty"`
+}
+
+// isJsonMediaType checks for application/json and the +json types
+func isJsonMediaType(mediaType string) bool {
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// textOrBase64 keeps the raw as text when possible, as base64 when not
+func textOrBase64(raw []byte) (interface{}, string) {
+	if utf8.Valid(raw) {
+		return string(raw), BodyText
+	}
+
+	return base64.StdEncoding.EncodeToString(raw), BodyBase64
+}
+
+// FormToBody converts the form values, fields with more than one value keep
+// them as a list, the query of the records is kept the same way
+func FormToBody(values url.Values) map[string]interface{} {
+	body := make(map[string]interface{})
+	for k, v := range values {
+		if len(v) == 1 {
+			body[k] = v[0]
+			continue
+		}
+
+		list := []interface{}{}
+		for _, item := range v {
+			list = append(list, item)
+		}
+		body[k] = list
+	}
+
+	return body
+}
+
+// multipartToParts reads all the parts of a multipart body
+func multipartToParts(raw []byte, boundary string) ([]BodyPart, error) {
+	parts := []BodyPart{}
+	reader := multipart.NewReader(bytes.NewReader(raw), boundary)
+
+	for {
+		p, err := reader.NextPart()
+		if err == io.EOF {
+			return parts, nil
+		}
+		if err != nil {
+			return parts, err
+		}
+
+		content, err := io.ReadAll(p)
+		if err != nil {
+			return parts, err
+		}
+
+		part := BodyPart{
+			Name:        p.FormName(),
+			Filename:    p.FileName(),
+			ContentType: p.Header.Get("Content-Type"),
+		}
+
+		if utf8.Valid(content) {
+			part.Value = string(content)
+		} else {
+			part.Data = base64.StdEncoding.EncodeToString(content)
+		}
+
+		parts = append(parts, part)
+	}
+}
+
+// EncodeBody converts a raw body into the body and its encoding kept on the
+// record, the content type decides the encoding, json is used when there is
+// none and the body is valid json
+func EncodeBody(contentType string, raw []byte) (interface{}, string) {
+	if len(raw) == 0 {
+		return nil, ""
+	}
+
+	mediaType, params, _ := mime.ParseMediaType(contentType)
+
+	switch {
+	case strings.HasPrefix(mediaType, "multipart/") && len(params["boundary"]) > 0:
+		parts, err := multipartToParts(raw, params["boundary"])
+		if err != nil {
+			return textOrBase64(raw)
+		}
+
+		return parts, BodyMultipart
+	case mediaType == "application/x-www-form-urlencoded":
+		values, err := url.ParseQuery(string(raw))
+		if err != nil {
+			return textOrBase64(raw)
+		}
+
+		return FormToBody(values), BodyForm
+	case isJsonMediaType(mediaType) || len(mediaType) == 0:
+		var body interface{}
+		if err := json.Unmarshal(raw, &body); err == nil {
+			return body, BodyJson
+		}
+	}
+
+	return textOrBase64(raw)
+}
+
+// bodyToString returns a value of the body as a string
+func bodyToString(v interface{}) string {
+	switch value := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	default:
+		raw, _ := json.Marshal(value)
+		return string(raw)
+	}
+}
+
+// BodyToForm converts a form body to its values
+func BodyToForm(body interface{}) (url.Values, error) {
+	fields, ok := body.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("form body has to be an object")
+	}
+
+	values := url.Values{}
+	for k, v := range fields {
+		list, ok := v.([]interface{})
+		if !ok {
+			values.Add(k, bodyToString(v))
+			continue
+		}
+
+		for _, item := range list {
+			values.Add(k, bodyToString(item))
+		}
+	}
+
+	return values, nil
+}
+
+// BodyToParts converts a multipart body to its parts, the parts may come
+// as they are or decoded from json as a list of objects
+func BodyToParts(body interface{}) ([]BodyPart, error) {
+	if parts, ok := body.([]BodyPart); ok {
+		return parts, nil
+	}
+
+	raw, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	parts := []BodyPart{}
+	if err := json.Unmarshal(raw, &parts); err != nil {
+		return nil, errors.New("multipart body has to be a list of parts")
+	}
+
+	return parts, nil
+}
+
+// quoteEscaper escapes the names on the part headers as mime/multipart does
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+// partsToMultipart writes the parts, the recorded content type is kept when
+// it is multipart so its subtype, parameters and boundary are still valid
+func partsToMultipart(parts []BodyPart, contentType string) ([]byte, string, error) {
+	buf := &bytes.Buffer{}
+	w := multipart.NewWriter(buf)
+
+	mediaType, params, _ := mime.ParseMediaType(contentType)
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		mediaType = "multipart/form-data"
+		params = make(map[string]string)
+	}
+
+	if len(params["boundary"]) > 0 {
+		if err := w.SetBoundary(params["boundary"]); err != nil {
+			return nil, "", err
+		}
+	}
+	params["boundary"] = w.Boundary()
+
+	for _, part := range parts {
+		disposition := fmt.Sprintf(`form-data; name="%s"`, quoteEscaper.Replace(part.Name))
+		if len(part.Filename) > 0 {
+			disposition += fmt.Sprintf(`; filename="%s"`, quoteEscaper.Replace(part.Filename))
+		}
+
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Disposition", disposition)
+		if len(part.ContentType) > 0 {
+			header.Set("Content-Type", part.ContentType)
+		}
+
+		pw, err := w.CreatePart(header)
+		if err != nil {
+			return nil, "", err
+		}
+
+		content := []byte(part.Value)
+		if len(part.Data) > 0 {
+			content, err = base64.StdEncoding.DecodeString(part.Data)
+			if err != nil {
+				return nil, "", err
+			}
+		}
+
+		if _, err := pw.Write(content); err != nil {
+			return nil, "", err
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, "", err
+	}
+
+	return buf.Bytes(), mime.FormatMediaType(mediaType, params), nil
+}
+
+// DecodeBody converts the body of a record back to the raw bytes to send and
+// the content type to send them with, the content type provided is kept
+// unless it is empty or doesn't match the body (ie: multipart boundaries)
+func DecodeBody(body interface{}, encoding string, contentType string) ([]byte, string, error) {
+	if body == nil {
+		return nil, contentType, nil
+	}
+
+	withDefault := func(defaultType string) string {
+		if len(contentType) == 0 {
+			return defaultType
+		}
+
+		return contentType
+	}
+
+	switch encoding {
+	case "", BodyJson:
+		raw, err := json.Marshal(body)
+		return raw, withDefault("application/json"), err
+	case BodyText:
+		return []byte(bodyToString(body)), withDefault("text/plain; charset=utf-8"), nil
+	case BodyBase64:
+		raw, err := base64.StdEncoding.DecodeString(bodyToString(body))
+		return raw, withDefault("application/octet-stream"), err
+	case BodyForm:
+		values, err := BodyToForm(body)
+		if err != nil {
+			return nil, contentType, err
+		}
+
+		return []byte(values.Encode()), withDefault("application/x-www-form-urlencoded"), nil
+	case BodyMultipart:
+		parts, err := BodyToParts(body)
+		if err != nil {
+			return nil, contentType, err
+		}
+
+		return partsToMultipart(parts, contentType)
+	}
+
+	return nil, contentType, errors.New("body encoding not supported: " + encoding)
+}
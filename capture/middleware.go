@@ -0,0 +1,34 @@
+package capture
+
+import (
+	"log"
+	"net/http"
+)
+
+// Handler captures every request into the writer before handing it to next,
+// failing to capture doesn't stop the request
+func Handler(next http.Handler, w Writer) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		record, err := NewRecord(r)
+		if err == nil {
+			var raw string
+			raw, err = record.Marshal()
+			if err == nil {
+				err = w.WriteRecord(raw)
+			}
+		}
+
+		if err != nil {
+			log.Println("failed to capture request:", err)
+		}
+
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// Middleware returns the capture handler on the usual middleware signature
+func Middleware(w Writer) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return Handler(next, w)
+	}
+}
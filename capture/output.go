@@ -0,0 +1,89 @@
+package capture
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Output is a file opened for appending, the content is compressed when the
+// file ends with .gz or .zst, it is a single stream until closed
+type Output struct {
+	// Empty is set when the file had no content when opened
+	Empty bool
+
+	file       *os.File
+	compressor io.WriteCloser
+}
+
+// flusher is implemented by the gzip and zstd writers
+type flusher interface {
+	Flush() error
+}
+
+// OpenOutput opens the file for appending, it is created when missing
+func OpenOutput(path string) (*Output, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	o := &Output{Empty: info.Size() == 0, file: f}
+
+	switch {
+	case strings.HasSuffix(path, ".gz"):
+		o.compressor = gzip.NewWriter(f)
+		break
+	case strings.HasSuffix(path, ".zst"):
+		o.compressor, err = zstd.NewWriter(f)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		break
+	}
+
+	return o, nil
+}
+
+// Write writes to the file, compressed if needed
+func (o *Output) Write(p []byte) (int, error) {
+	if o.compressor != nil {
+		return o.compressor.Write(p)
+	}
+
+	return o.file.Write(p)
+}
+
+// Flush writes what the compressor holds so it reaches the file without
+// ending the stream, a crash doesn't lose what was flushed
+func (o *Output) Flush() error {
+	if f, ok := o.compressor.(flusher); ok {
+		return f.Flush()
+	}
+
+	return nil
+}
+
+// Close ends the compressed stream and closes the file
+func (o *Output) Close() error {
+	var err error
+	if o.compressor != nil {
+		err = o.compressor.Close()
+	}
+
+	if fErr := o.file.Close(); fErr != nil && err == nil {
+		err = fErr
+	}
+
+	return err
+}
@@ -0,0 +1,163 @@
+// Package capture serialises incoming http requests into the record format
+// of the request analyser, so services can feed it directly
+package capture
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// headers that are only meaningful for the connection between client and server
+var hopHeaders = map[string]bool{
+	"Connection":          true,
+	"Keep-Alive":          true,
+	"Proxy-Authenticate":  true,
+	"Proxy-Authorization": true,
+	"Proxy-Connection":    true,
+	"Te":                  true,
+	"Trailer":             true,
+	"Transfer-Encoding":   true,
+	"Upgrade":             true,
+	"Content-Length":      true,
+}
+
+// FormatName and FormatVersion identify the record files, they are written on
+// the header line so readers know how to handle the records that follow
+const (
+	FormatName    = "request_analyser"
+	FormatVersion = 1
+)
+
+// Header is the first line of a record file
+type Header struct {
+	Format  string `json:"format"`
+	Version int    `json:"version"`
+}
+
+// HeaderLine returns the header line of the current format
+func HeaderLine() string {
+	raw, _ := json.Marshal(Header{Format: FormatName, Version: FormatVersion})
+	return string(raw)
+}
+
+// MaxBodyBytes caps the body kept on a record, the rest of the body is still
+// handed to the next handler, it can be changed before serving
+var MaxBodyBytes int64 = 1 << 20
+
+// Record is a request on the record format, captured or read by the analyser
+type Record struct {
+	UnixMs        int64  `json:"unixMs"`
+	RequestMethod string `json:"requestMethod"`
+	RequestUrl    string `json:"requestUrl"`
+	// RequestQuery has the parameters of the url, a list when repeated
+	RequestQuery   map[string]interface{} `json:"requestQuery,omitempty"`
+	RequestHeaders map[string]interface{} `json:"requestHeaders"`
+	RequestBody    interface{}            `json:"requestBody"`
+	// RequestBodyEncoding tells how the body is kept, see the Body encodings
+	RequestBodyEncoding string `json:"requestBodyEncoding,omitempty"`
+	// RequestBodyTruncated is set when the body was over MaxBodyBytes, only
+	// its start is kept
+	RequestBodyTruncated bool `json:"requestBodyTruncated,omitempty"`
+}
+
+// UnmarshalJSON reads the record, the "unix" seconds of the json sources are
+// used when there is no "unixMs"
+func (r *Record) UnmarshalJSON(raw []byte) error {
+	type plainRecord Record
+	data := struct {
+		plainRecord
+		Unix int64 `json:"unix"`
+	}{}
+
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return err
+	}
+
+	*r = Record(data.plainRecord)
+	if r.UnixMs == 0 && data.Unix > 0 {
+		r.UnixMs = data.Unix * 1000
+	}
+
+	return nil
+}
+
+// replayBody reads the captured start of a body and then the rest of it
+type replayBody struct {
+	io.Reader
+	io.Closer
+}
+
+// NewRecord converts an incoming request to a record, the body is read up to
+// MaxBodyBytes and put back so the request can still be handled
+func NewRecord(r *http.Request) (Record, error) {
+	record := Record{
+		UnixMs:         time.Now().UnixMilli(),
+		RequestMethod:  r.Method,
+		RequestUrl:     r.URL.EscapedPath(),
+		RequestHeaders: make(map[string]interface{}),
+	}
+
+	// a query that can't be parsed stays on the url as it came
+	if len(r.URL.RawQuery) > 0 {
+		query, err := url.ParseQuery(r.URL.RawQuery)
+		if err != nil {
+			record.RequestUrl = r.URL.RequestURI()
+		} else {
+			record.RequestQuery = FormToBody(query)
+		}
+	}
+
+	for k, v := range r.Header {
+		if hopHeaders[k] || len(v) == 0 {
+			continue
+		}
+
+		// repeated headers are kept as a list
+		if len(v) == 1 {
+			record.RequestHeaders[k] = v[0]
+			continue
+		}
+
+		values := []interface{}{}
+		for _, item := range v {
+			values = append(values, item)
+		}
+		record.RequestHeaders[k] = values
+	}
+
+	if r.Body != nil {
+		// one more byte tells if the body goes over the limit
+		raw, err := io.ReadAll(io.LimitReader(r.Body, MaxBodyBytes+1))
+		if err != nil {
+			return record, err
+		}
+		r.Body = replayBody{io.MultiReader(bytes.NewReader(raw), r.Body), r.Body}
+
+		if int64(len(raw)) > MaxBodyBytes {
+			raw = raw[:MaxBodyBytes]
+			record.RequestBodyTruncated = true
+		}
+
+		record.RequestBody, record.RequestBodyEncoding = EncodeBody(r.Header.Get("Content-Type"), raw)
+	}
+
+	return record, nil
+}
+
+// Marshal converts the record to the json line format read by the analyser,
+// queries and bodies keep their "&", "<" and ">" readable
+func (r Record) Marshal() (string, error) {
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(r); err != nil {
+		return "", err
+	}
+
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
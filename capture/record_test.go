@@ -0,0 +1,38 @@
+package capture
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNewRecordTruncatesLongBodies(t *testing.T) {
+	limit := MaxBodyBytes
+	MaxBodyBytes = 4
+	defer func() { MaxBodyBytes = limit }()
+
+	r := httptest.NewRequest("POST", "/users?a=1&b", strings.NewReader("0123456789"))
+	r.Header.Set("Content-Type", "text/plain")
+
+	record, err := NewRecord(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if record.RequestBody != "0123" || !record.RequestBodyTruncated {
+		t.Errorf("body %v truncated %v, want 0123 truncated", record.RequestBody, record.RequestBodyTruncated)
+	}
+	if record.RequestUrl != "/users" || record.RequestQuery["a"] != "1" || record.RequestQuery["b"] != "" {
+		t.Errorf("url %q query %v, want /users and a=1&b", record.RequestUrl, record.RequestQuery)
+	}
+
+	// the handler still reads the whole body
+	raw, err := io.ReadAll(r.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(raw) != "0123456789" {
+		t.Errorf("forwarded body %q, want 0123456789", raw)
+	}
+}
@@ -0,0 +1,132 @@
+package capture
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// Writer receives each record line to keep
+type Writer interface {
+	WriteRecord(raw string) error
+}
+
+// FileWriter appends the records to a file, one per line, the header line is
+// written when the file is new, .gz and .zst files are compressed
+type FileWriter struct {
+	Path string
+
+	mu     sync.Mutex
+	output *Output
+}
+
+// NewFileWriter returns a writer appending to the file path
+func NewFileWriter(path string) *FileWriter {
+	return &FileWriter{Path: path}
+}
+
+// WriteRecord appends the record to the file, the file is kept open until
+// the writer is closed
+func (w *FileWriter) WriteRecord(raw string) error {
+	// requests may come concurrently, the file has to be written one at a time
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.output == nil {
+		output, err := OpenOutput(w.Path)
+		if err != nil {
+			return err
+		}
+		w.output = output
+
+		if output.Empty {
+			raw = HeaderLine() + "\n" + raw
+		}
+	}
+
+	if _, err := w.output.Write([]byte(raw + "\n")); err != nil {
+		return err
+	}
+
+	// each record reaches the file as it comes
+	return w.output.Flush()
+}
+
+// Close closes the file, compressed files are only complete once closed
+func (w *FileWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.output == nil {
+		return nil
+	}
+
+	err := w.output.Close()
+	w.output = nil
+	return err
+}
+
+// RedisKeyWriter sets each record on its own key, the keys share a prefix
+// so they can be fetched with a pattern (ie: "req_record_*")
+type RedisKeyWriter struct {
+	Client     *redis.Client
+	Prefix     string
+	Expiration time.Duration
+
+	count uint64
+}
+
+// NewRedisKeyWriter returns a writer setting a key per record
+func NewRedisKeyWriter(client *redis.Client, prefix string) *RedisKeyWriter {
+	return &RedisKeyWriter{Client: client, Prefix: prefix}
+}
+
+// WriteRecord sets the record on a new key
+func (w *RedisKeyWriter) WriteRecord(raw string) error {
+	// the counter makes sure keys on the same nanosecond don't collide
+	count := atomic.AddUint64(&w.count, 1)
+	key := fmt.Sprintf("%s%d_%d", w.Prefix, time.Now().UnixNano(), count)
+
+	return w.Client.Set(key, raw, w.Expiration).Err()
+}
+
+// RedisListWriter pushes the records to the end of a list
+type RedisListWriter struct {
+	Client *redis.Client
+	Key    string
+}
+
+// NewRedisListWriter returns a writer pushing to the list key
+func NewRedisListWriter(client *redis.Client, key string) *RedisListWriter {
+	return &RedisListWriter{Client: client, Key: key}
+}
+
+// WriteRecord pushes the record to the list
+func (w *RedisListWriter) WriteRecord(raw string) error {
+	return w.Client.RPush(w.Key, raw).Err()
+}
+
+// RedisStreamWriter adds the records to a stream under the "record" field
+type RedisStreamWriter struct {
+	Client *redis.Client
+	Key    string
+	// MaxLen caps the stream approximately, 0 keeps everything
+	MaxLen int64
+}
+
+// NewRedisStreamWriter returns a writer adding to the stream key
+func NewRedisStreamWriter(client *redis.Client, key string) *RedisStreamWriter {
+	return &RedisStreamWriter{Client: client, Key: key}
+}
+
+// WriteRecord adds the record to the stream
+func (w *RedisStreamWriter) WriteRecord(raw string) error {
+	return w.Client.XAdd(&redis.XAddArgs{
+		Stream:       w.Key,
+		MaxLenApprox: w.MaxLen,
+		Values:       map[string]interface{}{"record": raw},
+	}).Err()
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// compressedReader closes the decompressor together with the file
+type compressedReader struct {
+	io.Reader
+	closers []func() error
+}
+
+func (r *compressedReader) Close() error {
+	var err error
+	for _, c := range r.closers {
+		if cErr := c(); cErr != nil && err == nil {
+			err = cErr
+		}
+	}
+
+	return err
+}
+
+// openInput opens a file, or the stdin, decompressing it on the fly when it
+// is gzip or zstd, the format is detected by its first bytes
+func openInput(path string) (io.ReadCloser, error) {
+	file := os.Stdin
+	if path != stdinPath {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+
+		file = f
+	}
+
+	closeFile := func() error {
+		if file == os.Stdin {
+			return nil
+		}
+
+		return file.Close()
+	}
+
+	reader := bufio.NewReader(file)
+
+	// not enough bytes means it can't be compressed, no need to error
+	magic, _ := reader.Peek(len(zstdMagic))
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		gz, err := gzip.NewReader(reader)
+		if err != nil {
+			closeFile()
+			return nil, err
+		}
+
+		return &compressedReader{gz, []func() error{gz.Close, closeFile}}, nil
+	case bytes.HasPrefix(magic, zstdMagic):
+		zr, err := zstd.NewReader(reader)
+		if err != nil {
+			closeFile()
+			return nil, err
+		}
+
+		closeZstd := func() error {
+			zr.Close()
+			return nil
+		}
+
+		return &compressedReader{zr, []func() error{closeZstd, closeFile}}, nil
+	}
+
+	return &compressedReader{reader, []func() error{closeFile}}, nil
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// coverageOperation is a documented operation with the requests matching it
+type coverageOperation struct {
+	Method      string `json:"method"`
+	Path        string `json:"path"`
+	OperationId string `json:"operationId,omitempty"`
+	Count       int    `json:"count"`
+	// Routes are the observed routes matching the operation
+	Routes []string `json:"routes,omitempty"`
+	// literals is the length of the path without its parameters, the most
+	// specific operations are matched first (ie: /users/me over /users/{id})
+	literals int
+	regex    *regexp.Regexp
+}
+
+// coverageRequest is an observed request without a documented operation
+type coverageRequest struct {
+	Method string `json:"method"`
+	Url    string `json:"url"`
+	Count  int    `json:"count"`
+	// PathDocumented is set when the path has operations for other methods
+	PathDocumented bool `json:"pathDocumented,omitempty"`
+}
+
+// coverageReport compares the documented operations with the observed ones
+type coverageReport struct {
+	Operations   int                  `json:"operations"`
+	Exercised    []*coverageOperation `json:"exercised"`
+	NeverCalled  []*coverageOperation `json:"neverCalled"`
+	Undocumented []coverageRequest    `json:"undocumented"`
+	// Requests counts the requests, the documented ones are those matching
+	Requests           int     `json:"requests"`
+	DocumentedRequests int     `json:"documentedRequests"`
+	Coverage           float64 `json:"coverage"`
+}
+
+// specPathRegex converts an openapi path to a regex, a parameter matches any
+// part of a segment
+func specPathRegex(path string) *regexp.Regexp {
+	parts := openapiPathParamRegex.Split(path, -1)
+	for i, p := range parts {
+		parts[i] = regexp.QuoteMeta(p)
+	}
+
+	return regexp.MustCompile("^" + strings.Join(parts, "[^/]+") + "/?$")
+}
+
+// specOperations returns the operations of the document, the paths are
+// under the path of the first server
+func specOperations(doc *openapiDocument) []*coverageOperation {
+	basePath := doc.serverBasePath()
+	operations := []*coverageOperation{}
+
+	for path, pathItem := range doc.Paths {
+		if pathItem == nil {
+			continue
+		}
+
+		for method, op := range pathItem.operations() {
+			if op == nil {
+				continue
+			}
+
+			fullPath := basePath + path
+			operations = append(operations, &coverageOperation{
+				Method:      method,
+				Path:        fullPath,
+				OperationId: op.OperationId,
+				literals:    len(openapiPathParamRegex.ReplaceAllString(fullPath, "")),
+				regex:       specPathRegex(fullPath),
+			})
+		}
+	}
+
+	sort.Slice(operations, func(i, j int) bool {
+		if operations[i].literals != operations[j].literals {
+			return operations[i].literals > operations[j].literals
+		}
+		if operations[i].Path != operations[j].Path {
+			return operations[i].Path < operations[j].Path
+		}
+
+		return operations[i].Method < operations[j].Method
+	})
+
+	return operations
+}
+
+// coverage matches the requests of the records, grouped as the stats group
+// them, against the operations of an openapi document
+func coverage(specPath string, inputPath string, routePatterns []string) (coverageReport, error) {
+	report := coverageReport{
+		Exercised:    []*coverageOperation{},
+		NeverCalled:  []*coverageOperation{},
+		Undocumented: []coverageRequest{},
+	}
+
+	if len(specPath) == 0 {
+		return report, errors.New("spec path is required")
+	}
+
+	f, err := os.Open(specPath)
+	if err != nil {
+		return report, err
+	}
+	defer f.Close()
+
+	doc, err := loadOpenApi(f)
+	if err != nil {
+		return report, err
+	}
+
+	operations := specOperations(doc)
+	report.Operations = len(operations)
+
+	// the paths of the document group the requests, the most specific first
+	// as the operations are, before the routes provided and the inferred ones
+	patterns := []string{}
+	for _, op := range operations {
+		patterns = append(patterns, op.Path)
+	}
+	patterns = append(patterns, routePatterns...)
+
+	data, err := stats(inputPath, 0, patterns, routeCardinalityLimit, false, 0, false, false, false, nil)
+	if err != nil {
+		return report, err
+	}
+	report.Requests = data.count
+
+	for _, r := range data.requests {
+		_, path := splitUrlPath(r.url)
+
+		var matched *coverageOperation
+		pathDocumented := false
+		for _, op := range operations {
+			if !op.regex.MatchString(path) {
+				continue
+			}
+
+			pathDocumented = true
+			if op.Method == r.method {
+				matched = op
+				break
+			}
+		}
+
+		if matched == nil {
+			report.Undocumented = append(report.Undocumented, coverageRequest{
+				Method:         r.method,
+				Url:            r.url,
+				Count:          r.count,
+				PathDocumented: pathDocumented,
+			})
+			continue
+		}
+
+		matched.Count += r.count
+		matched.Routes = append(matched.Routes, r.url)
+		report.DocumentedRequests += r.count
+	}
+
+	for _, op := range operations {
+		if op.Count > 0 {
+			report.Exercised = append(report.Exercised, op)
+			continue
+		}
+
+		report.NeverCalled = append(report.NeverCalled, op)
+	}
+
+	// the most used first, the never called ones by path
+	sort.SliceStable(report.Exercised, func(i, j int) bool {
+		return report.Exercised[i].Count > report.Exercised[j].Count
+	})
+	sort.SliceStable(report.NeverCalled, func(i, j int) bool {
+		if report.NeverCalled[i].Path == report.NeverCalled[j].Path {
+			return report.NeverCalled[i].Method < report.NeverCalled[j].Method
+		}
+
+		return report.NeverCalled[i].Path < report.NeverCalled[j].Path
+	})
+
+	if report.Operations > 0 {
+		report.Coverage = float64(len(report.Exercised)) / float64(report.Operations)
+	}
+
+	return report, nil
+}
+
+// undocumentedText describes an undocumented request on a line
+func undocumentedText(r coverageRequest) string {
+	text := fmt.Sprintf("%s %s (%d requests)", r.Method, r.Url, r.Count)
+	if r.PathDocumented {
+		text += ", the path is documented for other methods"
+	}
+
+	return text
+}
+
+// coverageToText converts the coverage to the text we print
+func coverageToText(report coverageReport) string {
+	lines := []string{
+		fmt.Sprintf(
+			"coverage: %d of %d operations exercised (%.1f%%)",
+			len(report.Exercised),
+			report.Operations,
+			report.Coverage*100,
+		),
+		fmt.Sprintf(
+			"requests: %d, %d documented, %d undocumented",
+			report.Requests,
+			report.DocumentedRequests,
+			report.Requests-report.DocumentedRequests,
+		),
+		"",
+		"exercised:",
+	}
+
+	for _, op := range report.Exercised {
+		lines = append(lines, fmt.Sprintf("    %s %s (%d requests)", op.Method, op.Path, op.Count))
+	}
+
+	lines = append(lines, "", "never called:")
+	for _, op := range report.NeverCalled {
+		lines = append(lines, fmt.Sprintf("    %s %s", op.Method, op.Path))
+	}
+
+	lines = append(lines, "", "undocumented:")
+	for _, r := range report.Undocumented {
+		lines = append(lines, "    "+undocumentedText(r))
+	}
+
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// coverageToMarkdown converts the coverage to markdown, ready for PR comments
+func coverageToMarkdown(report coverageReport) string {
+	lines := []string{
+		"## API coverage",
+		"",
+		fmt.Sprintf(
+			"**%d** of **%d** operations exercised (%.1f%%), %d of %d requests documented",
+			len(report.Exercised),
+			report.Operations,
+			report.Coverage*100,
+			report.DocumentedRequests,
+			report.Requests,
+		),
+		"",
+		"### Exercised",
+		"",
+		"| Method | Path | Requests |",
+		"| --- | --- | ---: |",
+	}
+
+	for _, op := range report.Exercised {
+		lines = append(lines, fmt.Sprintf(
+			"| %s | `%s` | %d |",
+			markdownEscape(op.Method),
+			markdownEscape(op.Path),
+			op.Count,
+		))
+	}
+
+	lines = append(lines, "", "### Never called", "")
+	for _, op := range report.NeverCalled {
+		lines = append(lines, fmt.Sprintf("- %s `%s`", op.Method, markdownEscape(op.Path)))
+	}
+
+	lines = append(lines, "", "### Undocumented", "")
+	for _, r := range report.Undocumented {
+		lines = append(lines, "- "+markdownEscape(undocumentedText(r)))
+	}
+
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// writeCoverage writes the coverage on the format, an empty output path
+// writes to the stdout
+func writeCoverage(report coverageReport, outputPath string, format string) error {
+	var output string
+	switch strings.ToLower(format) {
+	case "", "text":
+		output = coverageToText(report)
+		break
+	case "json":
+		raw, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		output = string(raw) + "\n"
+		break
+	case "markdown", "md":
+		output = coverageToMarkdown(report)
+		break
+	default:
+		return errors.New("coverage format not supported: " + format)
+	}
+
+	if len(outputPath) == 0 {
+		_, err := os.Stdout.WriteString(output)
+		return err
+	}
+
+	return os.WriteFile(outputPath, []byte(output), 0644)
+}
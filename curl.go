@@ -0,0 +1,397 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"request_analyser/capture"
+)
+
+// curl flags that come with a value we don't use, we need to skip the value
+var curlIgnoredValueFlags = map[string]bool{
+	"-o": true, "--output": true, "-m": true, "--max-time": true, "--connect-timeout": true,
+	"-x": true, "--proxy": true, "-w": true, "--write-out": true, "--cacert": true,
+	"--cert": true, "-E": true, "--key": true, "-T": true, "--upload-file": true,
+	"--retry": true, "-c": true, "--cookie-jar": true, "-r": true, "--range": true,
+	"--resolve": true, "--limit-rate": true, "-K": true, "--config": true,
+}
+
+// curl short flags that may have the value attached, ie: -XPOST
+var curlShortValueFlags = []string{"-X", "-H", "-d", "-u", "-b", "-A", "-e", "-F"}
+
+func isCurlSource(raw string) bool {
+	return strings.Index(firstLine(raw), "curl ") == 0
+}
+
+// splitShellWords splits a command line the way a shell would,
+// handling single, double and ansi-c ($'...') quotes
+func splitShellWords(line string) ([]string, error) {
+	words := []string{}
+	current := strings.Builder{}
+	inWord := false
+
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+
+		switch {
+		case c == ' ' || c == '\t':
+			if inWord {
+				words = append(words, current.String())
+				current.Reset()
+				inWord = false
+			}
+			break
+		case c == '\\' && i+1 < len(line):
+			i++
+			current.WriteByte(line[i])
+			inWord = true
+			break
+		case c == '\'':
+			end := strings.IndexByte(line[i+1:], '\'')
+			if end < 0 {
+				return words, errors.New("unterminated quote on curl command")
+			}
+
+			current.WriteString(line[i+1 : i+1+end])
+			i += end + 1
+			inWord = true
+			break
+		case c == '$' && i+1 < len(line) && line[i+1] == '\'':
+			i += 2
+			for ; i < len(line) && line[i] != '\''; i++ {
+				if line[i] != '\\' || i+1 >= len(line) {
+					current.WriteByte(line[i])
+					continue
+				}
+
+				i++
+				switch line[i] {
+				case 'n':
+					current.WriteByte('\n')
+					break
+				case 't':
+					current.WriteByte('\t')
+					break
+				case 'r':
+					current.WriteByte('\r')
+					break
+				default:
+					current.WriteByte(line[i])
+				}
+			}
+
+			if i >= len(line) {
+				return words, errors.New("unterminated quote on curl command")
+			}
+			inWord = true
+			break
+		case c == '"':
+			i++
+			for ; i < len(line) && line[i] != '"'; i++ {
+				if line[i] == '\\' && i+1 < len(line) && strings.IndexByte("\"\\$`", line[i+1]) >= 0 {
+					i++
+				}
+
+				current.WriteByte(line[i])
+			}
+
+			if i >= len(line) {
+				return words, errors.New("unterminated quote on curl command")
+			}
+			inWord = true
+			break
+		default:
+			current.WriteByte(c)
+			inWord = true
+		}
+	}
+
+	if inWord {
+		words = append(words, current.String())
+	}
+
+	return words, nil
+}
+
+// curlDataToBody converts the curl data into the body we keep on the source,
+// curl sends it url encoded unless a content type is provided, json is kept
+// as json when there is none
+func curlDataToBody(data []string, contentType string) (interface{}, string) {
+	if len(data) == 0 {
+		return nil, ""
+	}
+
+	raw := strings.Join(data, "&")
+	if len(contentType) == 0 && !json.Valid([]byte(raw)) {
+		contentType = "application/x-www-form-urlencoded"
+	}
+
+	return capture.EncodeBody(contentType, []byte(raw))
+}
+
+// readCurlDataFile reads the file of a @file data value, the stdin can't be
+// read as it holds the commands
+func readCurlDataFile(path string) (string, error) {
+	if path == "-" {
+		return "", errors.New("curl data from the stdin is not supported")
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("curl data file: %w", err)
+	}
+
+	return string(content), nil
+}
+
+// curlUrlEncode encodes a value the way curl does, spaces are %20
+func curlUrlEncode(value string) string {
+	return strings.ReplaceAll(url.QueryEscape(value), "+", "%20")
+}
+
+// curlDataValue returns the data sent for the value of a data flag, @file
+// values are read from the file and --data-urlencode values are encoded
+// (content, =content, name=content, @file or name@file)
+func curlDataValue(flag string, value string) (string, error) {
+	switch flag {
+	case "--data-raw":
+		return value, nil
+	case "--data-urlencode":
+		name := ""
+		content := value
+		if i := strings.IndexAny(value, "=@"); i >= 0 {
+			name = value[:i]
+			content = value[i+1:]
+
+			if value[i] == '@' {
+				file, err := readCurlDataFile(content)
+				if err != nil {
+					return "", err
+				}
+				content = file
+			}
+		}
+
+		if len(name) > 0 {
+			return name + "=" + curlUrlEncode(content), nil
+		}
+
+		return curlUrlEncode(content), nil
+	}
+
+	if strings.Index(value, "@") != 0 {
+		return value, nil
+	}
+
+	content, err := readCurlDataFile(value[1:])
+	if err != nil {
+		return "", err
+	}
+
+	// only --data-binary keeps the new lines of the file
+	if flag != "--data-binary" {
+		content = strings.ReplaceAll(content, "\r", "")
+		content = strings.ReplaceAll(content, "\n", "")
+	}
+
+	return content, nil
+}
+
+// curlFormToPart converts a -F field into a multipart part, files are
+// referenced by their name as their content isn't read
+func curlFormToPart(field string) (capture.BodyPart, bool) {
+	arr := strings.SplitN(field, "=", 2)
+	if len(arr) != 2 {
+		return capture.BodyPart{}, false
+	}
+
+	part := capture.BodyPart{Name: arr[0], Value: arr[1]}
+
+	if strings.Index(arr[1], "@") == 0 || strings.Index(arr[1], "<") == 0 {
+		options := strings.Split(arr[1][1:], ";")
+		part.Value = ""
+		part.Filename = filepath.Base(options[0])
+
+		for _, option := range options[1:] {
+			if strings.Index(option, "type=") == 0 {
+				part.ContentType = strings.TrimPrefix(option, "type=")
+			}
+		}
+	}
+
+	return part, true
+}
+
+// curlToSource converts a single curl command to the source we use on the tool
+func curlToSource(command string) (source, error) {
+	newSource := source{RequestHeaders: make(map[string]interface{})}
+
+	words, err := splitShellWords(command)
+	if err != nil {
+		return newSource, err
+	}
+
+	data := []string{}
+	form := []capture.BodyPart{}
+	isGet := false
+
+	for i := 1; i < len(words); i++ {
+		flag := words[i]
+		value := ""
+
+		// the short flags may have the value attached
+		for _, f := range curlShortValueFlags {
+			if strings.Index(flag, f) == 0 && len(flag) > len(f) {
+				value = flag[len(f):]
+				flag = f
+				break
+			}
+		}
+
+		// fetch the value of the flag if it needs one
+		nextValue := func() string {
+			if len(value) > 0 || i+1 >= len(words) {
+				return value
+			}
+
+			i++
+			return words[i]
+		}
+
+		switch flag {
+		case "-X", "--request":
+			newSource.RequestMethod = nextValue()
+			break
+		case "-H", "--header":
+			header := strings.SplitN(nextValue(), ":", 2)
+			if len(header) == 2 {
+				addSourceHeader(
+					newSource.RequestHeaders,
+					strings.TrimSpace(header[0]),
+					strings.TrimSpace(header[1]),
+				)
+			}
+			break
+		case "-d", "--data", "--data-raw", "--data-binary", "--data-ascii", "--data-urlencode":
+			value, err := curlDataValue(flag, nextValue())
+			if err != nil {
+				return newSource, err
+			}
+
+			data = append(data, value)
+			break
+		case "-F", "--form":
+			if part, ok := curlFormToPart(nextValue()); ok {
+				form = append(form, part)
+			}
+			break
+		case "-u", "--user":
+			credentials := base64.StdEncoding.EncodeToString([]byte(nextValue()))
+			newSource.RequestHeaders["Authorization"] = "Basic " + credentials
+			break
+		case "-b", "--cookie":
+			// without a "=" the value is a file to read cookies from
+			cookie := nextValue()
+			if strings.Contains(cookie, "=") {
+				newSource.RequestHeaders["Cookie"] = cookie
+			}
+			break
+		case "-A", "--user-agent":
+			newSource.RequestHeaders["User-Agent"] = nextValue()
+			break
+		case "-e", "--referer":
+			newSource.RequestHeaders["Referer"] = nextValue()
+			break
+		case "-G", "--get":
+			isGet = true
+			break
+		case "-I", "--head":
+			newSource.RequestMethod = "HEAD"
+			break
+		case "--url":
+			newSource.RequestUrl = nextValue()
+			break
+		default:
+			if curlIgnoredValueFlags[flag] {
+				i++
+				continue
+			}
+
+			// any other flag is a switch, the url is the one without a dash
+			if strings.Index(flag, "-") != 0 && len(newSource.RequestUrl) == 0 {
+				newSource.RequestUrl = flag
+			}
+		}
+	}
+
+	// with get, the data goes to the query instead of the body
+	if isGet && len(data) > 0 {
+		separator := "?"
+		if strings.Contains(newSource.RequestUrl, "?") {
+			separator = "&"
+		}
+
+		newSource.RequestUrl += separator + strings.Join(data, "&")
+		data = []string{}
+	}
+
+	newSource.RequestBody, newSource.RequestBodyEncoding = curlDataToBody(
+		data,
+		sourceHeader(newSource.RequestHeaders, "Content-Type"),
+	)
+	if len(form) > 0 {
+		newSource.RequestBody = form
+		newSource.RequestBodyEncoding = capture.BodyMultipart
+	}
+
+	// curl defaults to post when sending data
+	if len(newSource.RequestMethod) == 0 && newSource.RequestBody != nil {
+		newSource.RequestMethod = "POST"
+	}
+
+	return newSource, nil
+}
+
+// convertCurlSource takes curl command lines and writes their records, the
+// backslash continuations (as copied from the browser devtools) are joined
+func convertCurlSource(r io.Reader, w *recordWriter) error {
+	writeCommand := func(command string) error {
+		command = strings.TrimSpace(command)
+		if strings.Index(command, "curl ") != 0 {
+			return nil
+		}
+
+		newSource, err := curlToSource(command)
+		if err != nil {
+			return err
+		}
+
+		return w.write(sanitizeSources([]source{newSource}))
+	}
+
+	command := ""
+	err := readLines(r, func(line string) error {
+		// the command goes on on the next line
+		if strings.HasSuffix(line, "\\") {
+			command += strings.TrimSuffix(line, "\\") + " "
+			return nil
+		}
+
+		line = command + line
+		command = ""
+		return writeCommand(line)
+	})
+	if err != nil {
+		return err
+	}
+
+	// the last command may end on a continuation
+	return writeCommand(command)
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"request_analyser/capture"
+)
+
+// sourceUrl makes sure the request url is absolute, relative urls are
+// joined with the base url
+func sourceUrl(baseUrl string, requestUrl string) string {
+	if strings.Index(requestUrl, "http://") == 0 || strings.Index(requestUrl, "https://") == 0 {
+		return requestUrl
+	}
+
+	return strings.TrimSuffix(baseUrl, "/") + "/" + strings.TrimPrefix(requestUrl, "/")
+}
+
+// sortedHeaderKeys returns the header names sorted so the output is stable
+func sortedHeaderKeys(headers map[string]interface{}) []string {
+	keys := []string{}
+	for k := range headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// sortedFormKeys returns the form fields sorted so the output is stable
+func sortedFormKeys(values url.Values) []string {
+	keys := []string{}
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// shellQuote quotes a value to be safely used as a single shell word
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+// sourcesToCurl converts the sources to a script with a curl command per request
+func sourcesToCurl(sources []source, baseUrl string) (string, error) {
+	script := "#!/bin/sh\n"
+
+	for _, s := range sources {
+		body, contentType, err := sourceBody(s)
+		if err != nil {
+			return script, err
+		}
+
+		// binary bodies can't be on the command line, they are piped instead
+		isBinary := body != nil && !utf8.Valid(body)
+
+		script += "\n"
+		if isBinary {
+			script += fmt.Sprintf(
+				"printf '%%s' %s | base64 -d | ",
+				shellQuote(base64.StdEncoding.EncodeToString(body)),
+			)
+		}
+
+		script += fmt.Sprintf(
+			"curl -X %s %s",
+			sourceMethod(s),
+			shellQuote(sourceUrl(baseUrl, sourceRequestUrl(s))),
+		)
+
+		for _, k := range sortedHeaderKeys(s.RequestHeaders) {
+			// the content type has to match the body, it is set with it
+			if body != nil && strings.EqualFold(k, "Content-Type") {
+				continue
+			}
+
+			for _, v := range headerValues(s.RequestHeaders[k]) {
+				script += fmt.Sprintf(" \\\n  -H %s", shellQuote(k+": "+v))
+			}
+		}
+
+		if body != nil {
+			script += fmt.Sprintf(" \\\n  -H %s", shellQuote("Content-Type: "+contentType))
+
+			if isBinary {
+				script += " \\\n  --data-binary @-"
+			} else {
+				script += fmt.Sprintf(" \\\n  --data-raw %s", shellQuote(string(body)))
+			}
+		}
+
+		script += "\n"
+	}
+
+	return script, nil
+}
+
+// sourcesToHar converts the sources to a har file, responses are left empty
+// as we only know about the requests
+func sourcesToHar(sources []source, baseUrl string) (string, error) {
+	data := harData{}
+	data.Log.Version = "1.2"
+	data.Log.Creator = harCreator{Name: "request_analyser", Version: "1.0"}
+	data.Log.Entries = []harEntry{}
+
+	// har entries need a time, the requests without one take the export time
+	exportUnixMs := time.Now().UnixMilli()
+
+	for _, s := range sources {
+		requestUrl := sourceUrl(baseUrl, sourceRequestUrl(s))
+
+		unixMs := s.UnixMs
+		if unixMs == 0 {
+			unixMs = exportUnixMs
+		}
+
+		request := harRequest{
+			Method:      sourceMethod(s),
+			Url:         requestUrl,
+			HttpVersion: "HTTP/1.1",
+			Headers:     []harNameValue{},
+			QueryString: []harNameValue{},
+			Cookies:     []harNameValue{},
+			HeadersSize: -1,
+			BodySize:    0,
+		}
+
+		for _, k := range sortedHeaderKeys(s.RequestHeaders) {
+			for _, v := range headerValues(s.RequestHeaders[k]) {
+				request.Headers = append(request.Headers, harNameValue{Name: k, Value: v})
+			}
+		}
+
+		if u, err := url.Parse(requestUrl); err == nil {
+			for k, values := range u.Query() {
+				for _, v := range values {
+					request.QueryString = append(request.QueryString, harNameValue{Name: k, Value: v})
+				}
+			}
+		}
+
+		body, contentType, err := sourceBody(s)
+		if err != nil {
+			return "", err
+		}
+
+		if body != nil {
+			request.BodySize = len(body)
+			request.PostData = &harPostData{MimeType: contentType, Text: string(body)}
+
+			if !utf8.Valid(body) {
+				request.PostData.Text = base64.StdEncoding.EncodeToString(body)
+				request.PostData.Encoding = "base64"
+			}
+
+			// forms are described by their params as well
+			if s.RequestBodyEncoding == capture.BodyForm {
+				values, err := capture.BodyToForm(s.RequestBody)
+				if err != nil {
+					return "", err
+				}
+
+				for _, k := range sortedFormKeys(values) {
+					for _, v := range values[k] {
+						request.PostData.Params = append(
+							request.PostData.Params,
+							harParam{Name: k, Value: v},
+						)
+					}
+				}
+			}
+		}
+
+		data.Log.Entries = append(data.Log.Entries, harEntry{
+			StartedDateTime: time.UnixMilli(unixMs).UTC().Format(time.RFC3339Nano),
+			Request:         request,
+			Response: harResponse{
+				Headers:     []harNameValue{},
+				Cookies:     []harNameValue{},
+				HeadersSize: -1,
+				BodySize:    -1,
+			},
+			Cache: make(map[string]interface{}),
+		})
+	}
+
+	raw, err := json.MarshalIndent(data, "", "  ")
+	return string(raw) + "\n", err
+}
+
+// sourcesToJson converts the sources to the json array format we parse
+func sourcesToJson(sources []source) (string, error) {
+	raw, err := json.MarshalIndent(sources, "", "  ")
+	return string(raw) + "\n", err
+}
+
+// export reads the parsed records and writes them on a shareable format,
+// an empty output path writes to the stdout
+func export(
+	inputPath string,
+	outputPath string,
+	format string,
+	baseUrl string,
+	patterns []string,
+) error {
+	if len(inputPath) == 0 {
+		return errors.New("input path is required")
+	}
+
+	paths, err := inputPaths(inputPath)
+	if err != nil {
+		return err
+	}
+
+	// when patterns are provided, only the matching requests are exported
+	sources := []source{}
+	err = readRecords(paths, func(s source) error {
+		if len(patterns) == 0 || isSourceFiltered(s, patterns) {
+			sources = append(sources, s)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var output string
+	switch strings.ToLower(format) {
+	case "curl":
+		output, err = sourcesToCurl(sources, baseUrl)
+		break
+	case "har":
+		output, err = sourcesToHar(sources, baseUrl)
+		break
+	case "json":
+		output, err = sourcesToJson(sources)
+		break
+	default:
+		return errors.New("export format not supported: " + format)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if len(outputPath) == 0 {
+		_, err := os.Stdout.WriteString(output)
+		return err
+	}
+
+	return os.WriteFile(outputPath, []byte(output), 0644)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExportExamples(t *testing.T) {
+	for _, example := range []string{"requests.json", "requests.txt"} {
+		t.Run(example, func(t *testing.T) {
+			dir := t.TempDir()
+			recordsPath := filepath.Join(dir, "records")
+			if err := parse(filepath.Join("examples", example), recordsPath, "", ""); err != nil {
+				t.Fatal(err)
+			}
+
+			curlPath := filepath.Join(dir, "requests.sh")
+			if err := export(recordsPath, curlPath, "curl", "http://localhost:4040", nil); err != nil {
+				t.Fatal(err)
+			}
+
+			raw, err := os.ReadFile(curlPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			script := string(raw)
+
+			// the status example has no method, it is a GET
+			for _, expected := range []string{
+				"curl -X GET 'http://localhost:4040/status'",
+				"curl -X GET 'http://localhost:4040/users/list'",
+				"curl -X POST 'http://localhost:4040/users/login'",
+				"curl -X POST 'http://localhost:4040/notifications/count'",
+			} {
+				if !strings.Contains(script, expected) {
+					t.Errorf("curl export is missing %q:\n%s", expected, script)
+				}
+			}
+
+			harPath := filepath.Join(dir, "requests.har")
+			if err := export(recordsPath, harPath, "har", "http://localhost:4040", nil); err != nil {
+				t.Fatal(err)
+			}
+
+			raw, err = os.ReadFile(harPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var har harData
+			if err := json.Unmarshal(raw, &har); err != nil {
+				t.Fatal(err)
+			}
+
+			methods := []string{}
+			for _, entry := range har.Log.Entries {
+				methods = append(methods, entry.Request.Method)
+			}
+			if strings.Join(methods, " ") != "GET GET POST POST" {
+				t.Errorf("har methods %v, want GET GET POST POST", methods)
+			}
+		})
+	}
+}
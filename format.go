@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"request_analyser/capture"
+)
+
+// isRecordLine checks if the line is on the json lines record format
+func isRecordLine(line string) bool {
+	return strings.Index(strings.TrimSpace(line), "{") == 0
+}
+
+// isRecordSource checks if the raw is a record file, its first line has to
+// be the header of the format or a json object with the record keys
+func isRecordSource(raw string) bool {
+	for _, line := range strings.Split(raw, "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 || strings.Index(line, "#") == 0 {
+			continue
+		}
+
+		fields := make(map[string]json.RawMessage)
+		if !isRecordLine(line) || json.Unmarshal([]byte(line), &fields) != nil {
+			return false
+		}
+
+		if format, ok := fields["format"]; ok {
+			return string(format) == strconv.Quote(capture.FormatName)
+		}
+
+		_, hasTime := fields["unixMs"]
+		_, hasUrl := fields["requestUrl"]
+		return hasTime || hasUrl
+	}
+
+	return false
+}
+
+// recordLineToSource converts a json record line, false when the line is the
+// header of the file
+func recordLineToSource(line string) (source, bool, error) {
+	s := source{}
+
+	header := capture.Header{}
+	if err := json.Unmarshal([]byte(line), &header); err != nil {
+		return s, false, err
+	}
+
+	if len(header.Format) > 0 {
+		if header.Format != capture.FormatName {
+			return s, false, errors.New("record format not supported: " + header.Format)
+		}
+
+		if header.Version > capture.FormatVersion {
+			return s, false, fmt.Errorf(
+				"record format version %d is newer than the supported %d",
+				header.Version,
+				capture.FormatVersion,
+			)
+		}
+
+		return s, false, nil
+	}
+
+	if err := json.Unmarshal([]byte(line), &s); err != nil {
+		return s, false, err
+	}
+	s.RequestMethod = strings.ToUpper(s.RequestMethod)
+
+	return s, true, nil
+}
+
+// sourceToRecordLine converts the source to its json record line
+func sourceToRecordLine(s source) (string, error) {
+	return s.Marshal()
+}
+
+// recordBatchSize is the number of records kept before writing them
+const recordBatchSize = 1000
+
+// recordWriter writes records to an output kept open until closed, it is
+// created on the first record and new files start with the header line
+type recordWriter struct {
+	path   string
+	output *capture.Output
+	batch  []source
+	// count is the number of records written
+	count int
+}
+
+func newRecordWriter(path string) *recordWriter {
+	return &recordWriter{path: path, batch: []source{}}
+}
+
+// write queues the sources, they are written in batches
+func (w *recordWriter) write(data []source) error {
+	w.batch = append(w.batch, data...)
+	if len(w.batch) < recordBatchSize {
+		return nil
+	}
+
+	return w.flush()
+}
+
+// flush writes the queued sources
+func (w *recordWriter) flush() error {
+	if len(w.batch) == 0 {
+		return nil
+	}
+
+	raw := strings.Builder{}
+	if w.output == nil {
+		output, err := capture.OpenOutput(w.path)
+		if err != nil {
+			return err
+		}
+		w.output = output
+
+		if output.Empty {
+			raw.WriteString(capture.HeaderLine() + "\n")
+		}
+	}
+
+	for _, req := range normalizeSources(w.batch) {
+		line, err := sourceToRecordLine(req)
+		if err != nil {
+			return err
+		}
+
+		raw.WriteString(line + "\n")
+	}
+
+	w.count += len(w.batch)
+	w.batch = []source{}
+
+	_, err := w.output.Write([]byte(raw.String()))
+	return err
+}
+
+// Close writes the queued sources and closes the output
+func (w *recordWriter) Close() error {
+	err := w.flush()
+	if w.output == nil {
+		return err
+	}
+
+	if closeErr := w.output.Close(); err == nil {
+		err = closeErr
+	}
+	w.output = nil
+
+	return err
+}
+
+// writeRecordsFile writes the sources to a file through a record writer,
+// returns the number of records written
+func writeRecordsFile(path string, write func(w *recordWriter) error) (int, error) {
+	w := newRecordWriter(path)
+	err := write(w)
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+
+	return w.count, err
+}
+
+// writeRecords reads the records of all the inputs and writes them to the
+// output on the current format, merged in timestamp order
+func writeRecords(paths []string, outputPath string) error {
+	_, err := writeRecordsFile(outputPath, func(w *recordWriter) error {
+		return readRecords(paths, func(s source) error {
+			return w.write([]source{s})
+		})
+	})
+
+	return err
+}
+
+// migrate converts record files on the legacy ";;" format, or json lines
+// missing the header, to the current format, the output is replaced
+func migrate(inputPath string, outputPath string) error {
+	if len(outputPath) == 0 {
+		return errors.New("output path is required")
+	}
+
+	paths, err := inputPaths(inputPath)
+	if err != nil {
+		return err
+	}
+
+	// the same file may be written differently, ie: ./records and records
+	if output, err := os.Stat(outputPath); err == nil {
+		for _, path := range paths {
+			if input, err := os.Stat(path); err == nil && os.SameFile(input, output) {
+				return errors.New("output path can't be one of the inputs")
+			}
+		}
+	}
+
+	// the records go to a file next to the output, it replaces the output once
+	// complete, the name ends as the output so it is compressed the same way
+	tmp, err := os.CreateTemp(filepath.Dir(outputPath), ".migrate-*-"+filepath.Base(outputPath))
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	// the temp file is only readable by us, the output is as any other output
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+
+	if err := writeRecords(paths, tmp.Name()); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), outputPath)
+}
@@ -4,7 +4,9 @@ go 1.20
 
 require (
 	github.com/go-redis/redis v6.15.9+incompatible
+	github.com/klauspost/compress v1.17.0
 	github.com/shirou/gopsutil v3.21.11+incompatible
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+
+	"request_analyser/capture"
+)
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harParam struct {
+	Name        string `json:"name"`
+	Value       string `json:"value,omitempty"`
+	FileName    string `json:"fileName,omitempty"`
+	ContentType string `json:"contentType,omitempty"`
+}
+
+type harPostData struct {
+	MimeType string     `json:"mimeType"`
+	Text     string     `json:"text"`
+	Params   []harParam `json:"params,omitempty"`
+	// encoding isn't on the spec for requests, it is used the same way as
+	// on the response content so binary bodies can be kept
+	Encoding string `json:"encoding,omitempty"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	Url         string         `json:"url"`
+	HttpVersion string         `json:"httpVersion"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	Cookies     []harNameValue `json:"cookies"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+}
+
+type harContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HttpVersion string         `json:"httpVersion"`
+	Headers     []harNameValue `json:"headers"`
+	Cookies     []harNameValue `json:"cookies"`
+	Content     harContent     `json:"content"`
+	RedirectUrl string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+type harEntry struct {
+	StartedDateTime string                 `json:"startedDateTime"`
+	Time            float64                `json:"time"`
+	Request         harRequest             `json:"request"`
+	Response        harResponse            `json:"response"`
+	Cache           map[string]interface{} `json:"cache"`
+	Timings         harTimings             `json:"timings"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harData struct {
+	Log struct {
+		Version string     `json:"version"`
+		Creator harCreator `json:"creator"`
+		Entries []harEntry `json:"entries"`
+	} `json:"log"`
+}
+
+func isHarSource(raw string) bool {
+	return isJsonSingleSource(raw) && strings.Contains(raw, "\"log\":{") &&
+		strings.Contains(raw, "\"entries\":[")
+}
+
+// harPostDataToBody converts the har post data into the body we keep on the
+// source, the text is used when there is one and the params when not
+func harPostDataToBody(postData *harPostData) (interface{}, string) {
+	if postData == nil {
+		return nil, ""
+	}
+
+	if len(postData.Text) > 0 {
+		raw := []byte(postData.Text)
+		if postData.Encoding == "base64" {
+			if decoded, err := base64.StdEncoding.DecodeString(postData.Text); err == nil {
+				raw = decoded
+			}
+		}
+
+		return capture.EncodeBody(postData.MimeType, raw)
+	}
+
+	if len(postData.Params) == 0 {
+		return nil, ""
+	}
+
+	if strings.HasPrefix(postData.MimeType, "multipart/") {
+		parts := []capture.BodyPart{}
+		for _, p := range postData.Params {
+			parts = append(parts, capture.BodyPart{
+				Name:        p.Name,
+				Filename:    p.FileName,
+				ContentType: p.ContentType,
+				Value:       p.Value,
+			})
+		}
+
+		return parts, capture.BodyMultipart
+	}
+
+	values := url.Values{}
+	for _, p := range postData.Params {
+		values.Add(p.Name, p.Value)
+	}
+
+	return capture.EncodeBody("application/x-www-form-urlencoded", []byte(values.Encode()))
+}
+
+// harToSources takes a valid har document and converts each entry request
+// to the source we use on the tool
+func harToSources(r io.Reader) ([]source, error) {
+	var data harData
+	if err := json.NewDecoder(r).Decode(&data); err != nil {
+		return []source{}, err
+	}
+
+	sources := []source{}
+	for _, entry := range data.Log.Entries {
+		newSource := source{
+			RequestMethod:  entry.Request.Method,
+			RequestUrl:     entry.Request.Url,
+			RequestHeaders: make(map[string]interface{}),
+		}
+		newSource.RequestBody, newSource.RequestBodyEncoding = harPostDataToBody(
+			entry.Request.PostData,
+		)
+
+		// keep the original time of the request, har uses ISO 8601
+		if len(entry.StartedDateTime) > 0 {
+			t, err := time.Parse(time.RFC3339Nano, entry.StartedDateTime)
+			if err != nil {
+				return sources, err
+			}
+
+			newSource.UnixMs = t.UnixMilli()
+		}
+
+		for _, h := range entry.Request.Headers {
+			// http2 pseudo headers (":authority", ":path"...) aren't real headers
+			if strings.Index(h.Name, ":") == 0 {
+				continue
+			}
+
+			addSourceHeader(newSource.RequestHeaders, h.Name, h.Value)
+		}
+
+		sources = append(sources, newSource)
+	}
+
+	return sanitizeSources(sources), nil
+}
+
+// convertHarSource takes a valid har document and writes its records
+func convertHarSource(r io.Reader, w *recordWriter) error {
+	data, err := harToSources(r)
+	if err != nil {
+		return err
+	}
+
+	return w.write(data)
+}
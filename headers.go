@@ -0,0 +1,160 @@
+package main
+
+import (
+	"mime"
+	"net/textproto"
+	"regexp"
+	"strings"
+)
+
+// defaultRedactedHeaders are the headers whose values aren't reported, only
+// the scheme of the authorization is
+var defaultRedactedHeaders = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"Cookie",
+	"Set-Cookie",
+	"X-Api-Key",
+	"X-Auth-Token",
+	"X-Access-Token",
+	"X-Csrf-Token",
+	"X-Xsrf-Token",
+	"X-Amz-Security-Token",
+}
+
+// headerRareClientShare is the share of the requests up to which a client is
+// unusual
+const headerRareClientShare = 0.01
+
+// userAgentFamilies are the families found by a token of the user agent, in
+// the order they are checked as browsers mention each other
+var userAgentFamilies = []struct {
+	token  string
+	family string
+}{
+	{"curl/", "curl"},
+	{"Wget/", "Wget"},
+	{"PostmanRuntime/", "Postman"},
+	{"insomnia/", "Insomnia"},
+	{"python-requests/", "python-requests"},
+	{"Python-urllib/", "python-urllib"},
+	{"aiohttp/", "aiohttp"},
+	{"Go-http-client/", "Go http client"},
+	{"okhttp/", "okhttp"},
+	{"axios/", "axios"},
+	{"node-fetch/", "node-fetch"},
+	{"undici", "undici"},
+	{"Apache-HttpClient/", "Apache HttpClient"},
+	{"Java/", "Java"},
+	{"Dart/", "Dart"},
+	{"Edg/", "Edge"},
+	{"OPR/", "Opera"},
+	{"Chrome/", "Chrome"},
+	{"Firefox/", "Firefox"},
+	{"Safari/", "Safari"},
+	{"Trident/", "Internet Explorer"},
+	{"MSIE ", "Internet Explorer"},
+}
+
+var userAgentBotRegex = regexp.MustCompile(`(?i)bot|crawl|spider|slurp`)
+
+// userAgentFamily returns the client of a user agent without its version, ie:
+// curl/8.1.2 -> curl, unknown agents are their first product
+func userAgentFamily(userAgent string) string {
+	userAgent = strings.TrimSpace(userAgent)
+	if len(userAgent) == 0 {
+		return "(none)"
+	}
+
+	if userAgentBotRegex.MatchString(userAgent) {
+		return "bot"
+	}
+
+	for _, f := range userAgentFamilies {
+		if strings.Contains(userAgent, f.token) {
+			return f.family
+		}
+	}
+
+	product := strings.Fields(userAgent)[0]
+	if i := strings.Index(product, "/"); i > 0 {
+		product = product[:i]
+	}
+
+	return product
+}
+
+// headerStats has the usage of the headers of all the requests
+type headerStats struct {
+	// redact has the canonical names of the headers whose values are left out
+	redact map[string]bool
+	// headers has the requests and values of each header
+	headers         map[string]*paramStat
+	clients         map[string]int
+	contentTypes    map[string]int
+	acceptEncodings map[string]int
+	authSchemes     map[string]int
+}
+
+func newHeaderStats(redact []string) *headerStats {
+	h := &headerStats{
+		redact:          make(map[string]bool),
+		headers:         make(map[string]*paramStat),
+		clients:         make(map[string]int),
+		contentTypes:    make(map[string]int),
+		acceptEncodings: make(map[string]int),
+		authSchemes:     make(map[string]int),
+	}
+
+	for _, name := range redact {
+		h.redact[textproto.CanonicalMIMEHeaderKey(strings.TrimSpace(name))] = true
+	}
+
+	return h
+}
+
+// add counts the headers of a request, the names are case insensitive
+func (h *headerStats) add(headers map[string]interface{}) {
+	values := make(map[string][]string)
+	for k, v := range headers {
+		name := textproto.CanonicalMIMEHeaderKey(k)
+		values[name] = append(values[name], headerValues(v)...)
+	}
+
+	for name, v := range values {
+		header, ok := h.headers[name]
+		if !ok {
+			header = &paramStat{name: name, values: make(map[string]int)}
+			h.headers[name] = header
+		}
+
+		// redacted headers are only counted
+		if h.redact[name] {
+			header.add([]string{})
+			continue
+		}
+		header.add(v)
+	}
+
+	h.clients[userAgentFamily(sourceHeader(headers, "User-Agent"))] += 1
+
+	if contentType := sourceHeader(headers, "Content-Type"); len(contentType) > 0 {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			mediaType = strings.ToLower(contentType)
+		}
+		h.contentTypes[mediaType] += 1
+	}
+
+	// each of the encodings is counted, without their weight
+	for _, e := range strings.Split(sourceHeader(headers, "Accept-Encoding"), ",") {
+		e = strings.ToLower(strings.TrimSpace(strings.Split(e, ";")[0]))
+		if len(e) > 0 {
+			h.acceptEncodings[e] += 1
+		}
+	}
+
+	if fields := strings.Fields(sourceHeader(headers, "Authorization")); len(fields) > 0 {
+		h.authSchemes[strings.ToLower(fields[0])] += 1
+	}
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// stdinPath is the input used to read from the stdin
+const stdinPath = "-"
+
+// readLines calls the handler with each line of the reader without its line
+// break, the lines have no length limit
+func readLines(r io.Reader, handler func(line string) error) error {
+	reader := bufio.NewReader(r)
+
+	for {
+		raw, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+
+		if len(raw) > 0 {
+			if err := handler(strings.TrimRight(raw, "\r\n")); err != nil {
+				return err
+			}
+		}
+
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+// dirPaths returns the files inside a directory, recursively and sorted
+func dirPaths(dir string) ([]string, error) {
+	paths := []string{}
+
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		// hidden files and directories are not records
+		if path != dir && strings.Index(d.Name(), ".") == 0 {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if !d.IsDir() {
+			paths = append(paths, path)
+		}
+
+		return nil
+	})
+
+	sort.Strings(paths)
+	return paths, err
+}
+
+// inputPaths expands the input into the files to read, the input can be a
+// comma separated list of files, globs, directories or "-" for the stdin
+func inputPaths(input string) ([]string, error) {
+	paths := []string{}
+	seen := make(map[string]bool)
+
+	add := func(path string) {
+		if !seen[path] {
+			seen[path] = true
+			paths = append(paths, path)
+		}
+	}
+
+	for _, part := range strings.Split(input, ",") {
+		part = strings.TrimSpace(part)
+		if len(part) == 0 {
+			continue
+		}
+
+		if part == stdinPath {
+			add(part)
+			continue
+		}
+
+		matches, err := filepath.Glob(part)
+		if err != nil {
+			return paths, err
+		}
+
+		if len(matches) == 0 {
+			return paths, errors.New("no input found for " + part)
+		}
+
+		for _, match := range matches {
+			info, err := os.Stat(match)
+			if err != nil {
+				return paths, err
+			}
+
+			if !info.IsDir() {
+				add(match)
+				continue
+			}
+
+			dirFiles, err := dirPaths(match)
+			if err != nil {
+				return paths, err
+			}
+
+			for _, path := range dirFiles {
+				add(path)
+			}
+		}
+	}
+
+	if len(paths) == 0 {
+		return paths, errors.New("input path is required")
+	}
+
+	return paths, nil
+}
+
+// recordStream reads the records of a single input one line at a time, the
+// lines have no length limit as bodies can be large
+type recordStream struct {
+	path    string
+	reader  *bufio.Reader
+	pending []source
+	// lastUnixMs tells when the input isn't sorted, it is only reported once
+	lastUnixMs int64
+	unsorted   bool
+}
+
+// next makes sure there is a pending source, false once the input ended
+func (r *recordStream) next() (bool, error) {
+	for len(r.pending) == 0 {
+		raw, err := r.reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return false, err
+		}
+		if err == io.EOF && len(raw) == 0 {
+			return false, nil
+		}
+
+		line := strings.TrimRight(string(raw), "\r\n")
+		if len(line) == 0 || strings.Index(line, "#") == 0 {
+			continue
+		}
+
+		sources, err := rawToSource(line)
+		if err != nil {
+			return false, err
+		}
+
+		r.pending = sources
+	}
+
+	// the records without a time don't tell about the order
+	unixMs := r.pending[0].UnixMs
+	if !r.unsorted && unixMs > 0 && unixMs < r.lastUnixMs {
+		r.unsorted = true
+		log.Println("input", r.path, "is not sorted by time, its records are merged as they come")
+	}
+	if unixMs > 0 {
+		r.lastUnixMs = unixMs
+	}
+
+	return true, nil
+}
+
+// readRecords reads the records of all the inputs merged in timestamp
+// order, each input has to be sorted already (as parse and record write them)
+// so it is streamed instead of loaded into memory
+func readRecords(paths []string, handler func(s source) error) error {
+	streams := []*recordStream{}
+
+	for _, path := range paths {
+		r, err := openInput(path)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+
+		streams = append(streams, &recordStream{path: path, reader: bufio.NewReader(r)})
+	}
+
+	for {
+		// find the oldest of the pending sources, ties keep the inputs order
+		var oldest *recordStream
+		for _, stream := range streams {
+			ok, err := stream.next()
+			if err != nil {
+				return err
+			}
+
+			if ok && (oldest == nil || stream.pending[0].UnixMs < oldest.pending[0].UnixMs) {
+				oldest = stream
+			}
+		}
+
+		if oldest == nil {
+			return nil
+		}
+
+		s := oldest.pending[0]
+		oldest.pending = oldest.pending[1:]
+
+		if err := handler(s); err != nil {
+			return err
+		}
+	}
+}
+
+// sortRecordsFile sorts the records of a file by time, the ones with the
+// same time keep their order, sorted files are left as they are
+func sortRecordsFile(path string) error {
+	r, err := openInput(path)
+	if err != nil {
+		return err
+	}
+
+	// the file is being sorted, no need to tell it wasn't
+	stream := &recordStream{path: path, reader: bufio.NewReader(r), unsorted: true}
+	records := []source{}
+	sorted := true
+	for {
+		ok, err := stream.next()
+		if err != nil {
+			r.Close()
+			return err
+		}
+		if !ok {
+			break
+		}
+
+		s := stream.pending[0]
+		stream.pending = stream.pending[1:]
+		if len(records) > 0 && s.UnixMs < records[len(records)-1].UnixMs {
+			sorted = false
+		}
+		records = append(records, s)
+	}
+	r.Close()
+
+	if sorted {
+		return nil
+	}
+
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].UnixMs < records[j].UnixMs
+	})
+
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+
+	_, err = writeRecordsFile(path, func(w *recordWriter) error {
+		return w.write(records)
+	})
+
+	return err
+}
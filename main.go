@@ -7,58 +7,66 @@ import (
 	"log"
 	"os"
 	"strings"
+	"sync"
+
+	"request_analyser/capture"
 )
 
 func help() {
 	log.Println(
-		"Usage:\n./request_analyser <parse|stats|run> [options...]\n\nCheck documentation for more information",
+		"Usage:\n./request_analyser <parse|stats|run|export|record|migrate|spec|coverage> [options...]\n\nCheck documentation for more information",
 	)
 }
 
 type runnerWriter struct {
 	output string
+
+	// the output is kept open for the run so compressed results are a single
+	// stream, rows may come from concurrent requests
+	mu   sync.Mutex
+	file *capture.Output
 }
 
 // Write will receive a row and write it to csv and stdout
 func (w *runnerWriter) Write(v []byte) (int, error) {
-	// send to result tmp file
-	f, err := os.OpenFile(w.output,
-		os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	defer f.Close()
-	if err != nil {
-		return 1, err
-	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
 
-	// write to csv
-	arr := strings.Split(string(v), ";;")
-	values := []string{}
-	for _, v := range arr {
-		arr := strings.SplitN(v, ":", 1)
-		if len(arr) != 2 {
-			continue
+	if w.file == nil {
+		file, err := capture.OpenOutput(w.output)
+		if err != nil {
+			return 1, err
 		}
+		w.file = file
 
-		values = append(values, arr[1])
+		// new files start with the columns
+		if file.Empty {
+			wcsv := csv.NewWriter(file)
+			if err := wcsv.Write(runResultColumns); err != nil {
+				return 1, err
+			}
+			wcsv.Flush()
+		}
 	}
 
-	// TODO: split url by "/" and we can count and average per requests at each namespace
-	//       but it should be at the end and using the csv
-	///      should maybe use "stats" for this?
-
-	wcsv := csv.NewWriter(f)
-	err = wcsv.Write(values)
-	if err != nil {
+	// write to csv
+	wcsv := csv.NewWriter(w.file)
+	if err := wcsv.Write(runResultToRow(string(v))); err != nil {
 		return 1, err
 	}
 	wcsv.Flush()
+	if err := wcsv.Error(); err != nil {
+		return 1, err
+	}
 
-	if _, err := f.Write(v); err != nil {
+	// the row reaches the results file as it comes
+	if err := w.file.Flush(); err != nil {
 		return 1, err
 	}
 
 	// write to stdout
 	log.Println("===============================")
-	for _, v := range arr {
+	for _, v := range strings.Split(string(v), ";;") {
 		log.Println("-", v)
 	}
 	log.Println("===============================")
@@ -66,14 +74,67 @@ func (w *runnerWriter) Write(v []byte) (int, error) {
 	return 0, nil
 }
 
+// Close closes the results file, compressed results are only complete once
+// closed
+func (w *runnerWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return nil
+	}
+
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
+
 func main() {
 	parseFs := flag.NewFlagSet("parse", flag.ExitOnError)
 	parseSrcRaw := parseFs.String("s", "", "source of the records")
 	parseOutputRaw := parseFs.String("o", "tmp_parse", "output of the parsed records")
+	parseLogFormatRaw := parseFs.String(
+		"l",
+		"",
+		"access log format, combined|common or a nginx log_format string",
+	)
+	parseEnvRaw := parseFs.String("e", "", "postman environment file of the collection")
 	parseHelpRaw := parseFs.Bool("h", false, "help manual")
 
 	statsFs := flag.NewFlagSet("stats", flag.ExitOnError)
 	statsInputRaw := statsFs.String("i", "", "input with parsed records")
+	statsRoutesRaw := statsFs.String("r", "[]", "route patterns to group the requests by")
+	statsCardinalityRaw := statsFs.Int(
+		"cardinality",
+		routeCardinalityLimit,
+		"distinct segments under a path from which the rare ones are grouped as {id}, 0 never groups them",
+	)
+	statsResultsRaw := statsFs.Bool("results", false, "input are run results instead of records")
+	statsFormatRaw := statsFs.String("format", "text", "format of the stats, text|json|csv|markdown")
+	statsOutputRaw := statsFs.String("o", "", "output of the stats, stdout when empty")
+	statsLimitRaw := statsFs.Int("n", 20, "number of most used requests, 0 for all")
+	statsBodiesRaw := statsFs.Bool("bodies", false, "infers the json schema of the bodies per request")
+	statsExamplesRaw := statsFs.Bool(
+		"examples",
+		false,
+		"reports the outlier bodies with their values, they are redacted otherwise",
+	)
+	statsHeadersRaw := statsFs.Bool("headers", false, "reports the headers and clients of the requests")
+	statsRedactRaw := statsFs.String(
+		"redact",
+		"[]",
+		"headers whose values aren't reported, on top of the auth, cookie and token headers",
+	)
+	statsBucketRaw := statsFs.String(
+		"bucket",
+		"",
+		"counts the requests over time, second|minute|hour or a duration (ie: 5m)",
+	)
+	statsBucketRowsRaw := statsFs.Bool(
+		"buckets",
+		false,
+		"writes the csv with a row per bucket of the timeline instead of a row per request",
+	)
 	statsHelpRaw := statsFs.Bool("h", false, "help manual")
 
 	runFs := flag.NewFlagSet("run", flag.ExitOnError)
@@ -87,8 +148,53 @@ func main() {
 	runConcurrRaw := runFs.Int("c", 1, "number of concurrent requests")
 	runUnixRaw := runFs.Int("t", 500, "ms unix between requests")
 	runFilterRaw := runFs.String("f", "[]", "filters an array of patterns")
+	runRoutesRaw := runFs.String("r", "[]", "route patterns to report the requests by")
+	runGroupRaw := runFs.String(
+		"g",
+		"request_analyser",
+		"consumer group when running from a redis stream",
+	)
 	runHelpRaw := runFs.Bool("h", false, "help manual")
 
+	exportFs := flag.NewFlagSet("export", flag.ExitOnError)
+	exportInputRaw := exportFs.String("i", "", "input with parsed records")
+	exportOutputRaw := exportFs.String("o", "", "output of the export, stdout when empty")
+	exportFormatRaw := exportFs.String("f", "curl", "format of the export, curl|har|json")
+	exportBaseRaw := exportFs.String(
+		"b",
+		"http://localhost:4040",
+		"base url to use when no http|https provided",
+	)
+	exportPatternRaw := exportFs.String("p", "[]", "exports only an array of patterns")
+	exportHelpRaw := exportFs.Bool("h", false, "help manual")
+
+	recordFs := flag.NewFlagSet("record", flag.ExitOnError)
+	recordTargetRaw := recordFs.String("t", "", "target base url to forward the requests to")
+	recordAddressRaw := recordFs.String("a", ":4041", "address for the proxy to listen on")
+	recordOutputRaw := recordFs.String("o", "tmp_parse", "output of the recorded requests")
+	recordHelpRaw := recordFs.Bool("h", false, "help manual")
+
+	migrateFs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	migrateInputRaw := migrateFs.String("i", "", "input with records on an older format")
+	migrateOutputRaw := migrateFs.String("o", "", "output of the migrated records")
+	migrateHelpRaw := migrateFs.Bool("h", false, "help manual")
+
+	specFs := flag.NewFlagSet("spec", flag.ExitOnError)
+	specInputRaw := specFs.String("i", "", "input with parsed records")
+	specOutputRaw := specFs.String("o", "", "output of the openapi document, stdout when empty")
+	specFormatRaw := specFs.String("f", "yaml", "format of the openapi document, yaml|json")
+	specTitleRaw := specFs.String("t", "Recorded API", "title of the openapi document")
+	specRoutesRaw := specFs.String("r", "[]", "route patterns to group the requests by")
+	specHelpRaw := specFs.Bool("h", false, "help manual")
+
+	coverageFs := flag.NewFlagSet("coverage", flag.ExitOnError)
+	coverageSpecRaw := coverageFs.String("s", "", "openapi 3 document, yaml or json")
+	coverageInputRaw := coverageFs.String("i", "", "input with parsed records")
+	coverageOutputRaw := coverageFs.String("o", "", "output of the coverage, stdout when empty")
+	coverageFormatRaw := coverageFs.String("f", "text", "format of the coverage, text|json|markdown")
+	coverageRoutesRaw := coverageFs.String("r", "[]", "route patterns to group the requests by")
+	coverageHelpRaw := coverageFs.Bool("h", false, "help manual")
+
 	if len(os.Args) < 2 {
 		help()
 		return
@@ -115,14 +221,30 @@ func main() {
 			}
 		}
 
-		if err := run(
+		// parse the routes
+		routes := []string{}
+		if runRoutesRaw != nil && len(*runRoutesRaw) > 0 {
+			err := json.Unmarshal([]byte(*runRoutesRaw), &routes)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		results := &runnerWriter{output: *runOutputRaw}
+		err := run(
 			*runInputRaw,
 			*runBaseRaw,
 			*runConcurrRaw,
 			*runUnixRaw,
 			filter,
-			&runnerWriter{*runOutputRaw},
-		); err != nil {
+			routes,
+			*runGroupRaw,
+			results,
+		)
+		if closeErr := results.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
 			log.Fatal(err)
 		}
 		break
@@ -137,7 +259,7 @@ func main() {
 			return
 		}
 
-		if err := parse(*parseSrcRaw, *parseOutputRaw); err != nil {
+		if err := parse(*parseSrcRaw, *parseOutputRaw, *parseLogFormatRaw, *parseEnvRaw); err != nil {
 			log.Fatal(err)
 		}
 		break
@@ -152,30 +274,167 @@ func main() {
 			return
 		}
 
-		res, err := stats(*statsInputRaw, 20)
+		// parse the routes
+		routes := []string{}
+		if statsRoutesRaw != nil && len(*statsRoutesRaw) > 0 {
+			err := json.Unmarshal([]byte(*statsRoutesRaw), &routes)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		// parse the redacted headers, they are added to the defaults
+		redact := []string{}
+		if statsRedactRaw != nil && len(*statsRedactRaw) > 0 {
+			err := json.Unmarshal([]byte(*statsRedactRaw), &redact)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+		redact = append(redact, defaultRedactedHeaders...)
+
+		bucketMs, err := parseBucket(*statsBucketRaw)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		res, err := stats(
+			*statsInputRaw,
+			*statsLimitRaw,
+			routes,
+			*statsCardinalityRaw,
+			*statsResultsRaw,
+			bucketMs,
+			*statsBodiesRaw,
+			*statsExamplesRaw,
+			*statsHeadersRaw,
+			redact,
+		)
 		if err != nil {
 			log.Fatal(err)
 		}
 
-		log.Println("count:", res.count, "\n")
+		if err := writeStats(res, *statsOutputRaw, *statsFormatRaw, *statsBucketRowsRaw); err != nil {
+			log.Fatal(err)
+		}
+		break
+	case "export":
+		if err := exportFs.Parse(os.Args[2:]); err != nil {
+			exportFs.PrintDefaults()
+			log.Fatal(err)
+		}
+
+		if *exportHelpRaw {
+			exportFs.PrintDefaults()
+			return
+		}
+
+		// parse the patterns
+		patterns := []string{}
+		if exportPatternRaw != nil && len(*exportPatternRaw) > 0 {
+			err := json.Unmarshal([]byte(*exportPatternRaw), &patterns)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		if err := export(
+			*exportInputRaw,
+			*exportOutputRaw,
+			*exportFormatRaw,
+			*exportBaseRaw,
+			patterns,
+		); err != nil {
+			log.Fatal(err)
+		}
+		break
+	case "record":
+		if err := recordFs.Parse(os.Args[2:]); err != nil {
+			recordFs.PrintDefaults()
+			log.Fatal(err)
+		}
+
+		if *recordHelpRaw {
+			recordFs.PrintDefaults()
+			return
+		}
 
-		// methods
-		for k, v := range res.requestMethodCount {
-			log.Println("method count:", k, v)
+		if err := record(*recordTargetRaw, *recordAddressRaw, *recordOutputRaw); err != nil {
+			log.Fatal(err)
+		}
+		break
+	case "migrate":
+		if err := migrateFs.Parse(os.Args[2:]); err != nil {
+			migrateFs.PrintDefaults()
+			log.Fatal(err)
 		}
 
-		log.Println("\n")
+		if *migrateHelpRaw {
+			migrateFs.PrintDefaults()
+			return
+		}
 
-		// used requests
-		for i, v := range res.mostUsed {
-			log.Println(
-				"most used request (",
-				len(res.mostUsed)-i,
-				") :",
-				v.method,
-				v.url,
-				v.count,
-			)
+		if err := migrate(*migrateInputRaw, *migrateOutputRaw); err != nil {
+			log.Fatal(err)
+		}
+		break
+	case "spec":
+		if err := specFs.Parse(os.Args[2:]); err != nil {
+			specFs.PrintDefaults()
+			log.Fatal(err)
+		}
+
+		if *specHelpRaw {
+			specFs.PrintDefaults()
+			return
+		}
+
+		// parse the routes
+		routes := []string{}
+		if specRoutesRaw != nil && len(*specRoutesRaw) > 0 {
+			err := json.Unmarshal([]byte(*specRoutesRaw), &routes)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		if err := spec(
+			*specInputRaw,
+			*specOutputRaw,
+			*specFormatRaw,
+			*specTitleRaw,
+			routes,
+		); err != nil {
+			log.Fatal(err)
+		}
+		break
+	case "coverage":
+		if err := coverageFs.Parse(os.Args[2:]); err != nil {
+			coverageFs.PrintDefaults()
+			log.Fatal(err)
+		}
+
+		if *coverageHelpRaw {
+			coverageFs.PrintDefaults()
+			return
+		}
+
+		// parse the routes
+		routes := []string{}
+		if coverageRoutesRaw != nil && len(*coverageRoutesRaw) > 0 {
+			err := json.Unmarshal([]byte(*coverageRoutesRaw), &routes)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		res, err := coverage(*coverageSpecRaw, *coverageInputRaw, routes)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if err := writeCoverage(res, *coverageOutputRaw, *coverageFormatRaw); err != nil {
+			log.Fatal(err)
 		}
 		break
 	default:
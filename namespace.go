@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// namespaceNode is a level of the urls (ie: /api, /api/users), the stats of
+// all the requests under it are rolled up into it
+type namespaceNode struct {
+	path    string
+	count   int
+	methods map[string]int
+	// timed is the number of requests with an elapsed time, errors have none
+	timed     int
+	elapsedMs int64
+	errors    int
+	children  map[string]*namespaceNode
+}
+
+func newNamespaceNode(path string) *namespaceNode {
+	return &namespaceNode{
+		path:     path,
+		methods:  make(map[string]int),
+		children: make(map[string]*namespaceNode),
+	}
+}
+
+// record adds the stats of a request to this level only
+func (n *namespaceNode) record(req *reqStat) {
+	n.count += req.count
+	n.methods[req.method] += req.count
+	n.timed += req.timed
+	n.elapsedMs += req.elapsedMs
+	n.errors += req.errors
+}
+
+// add rolls the stats of a request up through every level of its route, the
+// host of absolute urls is a level on its own
+func (n *namespaceNode) add(req *reqStat) {
+	n.record(req)
+
+	prefix, path := splitUrlPath(req.url)
+
+	levels := []string{}
+	if len(prefix) > 0 {
+		levels = append(levels, prefix)
+	}
+	for _, s := range strings.Split(strings.Trim(path, "/"), "/") {
+		if len(s) > 0 {
+			levels = append(levels, s)
+		}
+	}
+
+	node := n
+	current := ""
+	for i, level := range levels {
+		if i == 0 && len(prefix) > 0 {
+			current = prefix
+		} else {
+			current += "/" + level
+		}
+
+		child, ok := node.children[level]
+		if !ok {
+			child = newNamespaceNode(current)
+			node.children[level] = child
+		}
+
+		child.record(req)
+		node = child
+	}
+}
+
+// avgElapsedMs returns the average time of the requests with a time
+func (n *namespaceNode) avgElapsedMs() float64 {
+	if n.timed == 0 {
+		return 0
+	}
+
+	return float64(n.elapsedMs) / float64(n.timed)
+}
+
+// sortedChildren returns the levels below, the most used first
+func (n *namespaceNode) sortedChildren() []*namespaceNode {
+	children := []*namespaceNode{}
+	for _, c := range n.children {
+		children = append(children, c)
+	}
+
+	sort.Slice(children, func(i, j int) bool {
+		if children[i].count == children[j].count {
+			return children[i].path < children[j].path
+		}
+
+		return children[i].count > children[j].count
+	})
+
+	return children
+}
+
+// methodMix returns the methods with their share, the most used first
+func (n *namespaceNode) methodMix() string {
+	methods := []string{}
+	for m := range n.methods {
+		methods = append(methods, m)
+	}
+
+	sort.Slice(methods, func(i, j int) bool {
+		if n.methods[methods[i]] == n.methods[methods[j]] {
+			return methods[i] < methods[j]
+		}
+
+		return n.methods[methods[i]] > n.methods[methods[j]]
+	})
+
+	mix := []string{}
+	for _, m := range methods {
+		mix = append(mix, fmt.Sprintf("%s %.0f%%", m, float64(n.methods[m])*100/float64(n.count)))
+	}
+
+	return strings.Join(mix, ", ")
+}
+
+// lines returns the tree as indented lines, one per level
+func (n *namespaceNode) lines(depth int) []string {
+	summary := fmt.Sprintf(
+		"%s%s: %d requests (%s)",
+		strings.Repeat("  ", depth),
+		n.path,
+		n.count,
+		n.methodMix(),
+	)
+
+	if n.timed > 0 {
+		summary += fmt.Sprintf(
+			", avg %.0fms, total %dms",
+			n.avgElapsedMs(),
+			n.elapsedMs,
+		)
+	}
+
+	if n.errors > 0 {
+		summary += fmt.Sprintf(", %d errors", n.errors)
+	}
+
+	lines := []string{summary}
+	for _, c := range n.sortedChildren() {
+		lines = append(lines, c.lines(depth+1)...)
+	}
+
+	return lines
+}
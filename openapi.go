@@ -0,0 +1,637 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"request_analyser/capture"
+)
+
+type openapiSchema struct {
+	Ref        string                    `json:"$ref,omitempty" yaml:"$ref,omitempty"`
+	Type       openapiSchemaType         `json:"type,omitempty" yaml:"type,omitempty"`
+	Format     string                    `json:"format,omitempty" yaml:"format,omitempty"`
+	Properties map[string]*openapiSchema `json:"properties,omitempty" yaml:"properties,omitempty"`
+	Required   []string                  `json:"required,omitempty" yaml:"required,omitempty"`
+	Items      *openapiSchema            `json:"items,omitempty" yaml:"items,omitempty"`
+	AllOf      []*openapiSchema          `json:"allOf,omitempty" yaml:"allOf,omitempty"`
+	OneOf      []*openapiSchema          `json:"oneOf,omitempty" yaml:"oneOf,omitempty"`
+	AnyOf      []*openapiSchema          `json:"anyOf,omitempty" yaml:"anyOf,omitempty"`
+	Enum       []interface{}             `json:"enum,omitempty" yaml:"enum,omitempty"`
+	Minimum    *float64                  `json:"minimum,omitempty" yaml:"minimum,omitempty"`
+	Nullable   bool                      `json:"nullable,omitempty" yaml:"nullable,omitempty"`
+	Example    interface{}               `json:"example,omitempty" yaml:"example,omitempty"`
+	Default    interface{}               `json:"default,omitempty" yaml:"default,omitempty"`
+}
+
+// openapiSchemaType is the type of the schema, openapi 3.1 allows a list
+// of types, we keep the first one that isn't null
+type openapiSchemaType string
+
+func (t *openapiSchemaType) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.SequenceNode {
+		return node.Decode((*string)(t))
+	}
+
+	types := []string{}
+	if err := node.Decode(&types); err != nil {
+		return err
+	}
+
+	for _, v := range types {
+		if v != "null" {
+			*t = openapiSchemaType(v)
+			break
+		}
+	}
+
+	return nil
+}
+
+type openapiExample struct {
+	Ref     string      `json:"$ref,omitempty" yaml:"$ref,omitempty"`
+	Summary string      `json:"summary,omitempty" yaml:"summary,omitempty"`
+	Value   interface{} `json:"value,omitempty" yaml:"value,omitempty"`
+}
+
+type openapiParameter struct {
+	Ref      string                     `json:"$ref,omitempty" yaml:"$ref,omitempty"`
+	Name     string                     `json:"name,omitempty" yaml:"name,omitempty"`
+	In       string                     `json:"in,omitempty" yaml:"in,omitempty"`
+	Required bool                       `json:"required,omitempty" yaml:"required,omitempty"`
+	Schema   *openapiSchema             `json:"schema,omitempty" yaml:"schema,omitempty"`
+	Example  interface{}                `json:"example,omitempty" yaml:"example,omitempty"`
+	Examples map[string]*openapiExample `json:"examples,omitempty" yaml:"examples,omitempty"`
+}
+
+type openapiMediaType struct {
+	Schema   *openapiSchema             `json:"schema,omitempty" yaml:"schema,omitempty"`
+	Example  interface{}                `json:"example,omitempty" yaml:"example,omitempty"`
+	Examples map[string]*openapiExample `json:"examples,omitempty" yaml:"examples,omitempty"`
+}
+
+type openapiRequestBody struct {
+	Ref      string                       `json:"$ref,omitempty" yaml:"$ref,omitempty"`
+	Required bool                         `json:"required,omitempty" yaml:"required,omitempty"`
+	Content  map[string]*openapiMediaType `json:"content,omitempty" yaml:"content,omitempty"`
+}
+
+type openapiResponse struct {
+	Description string `json:"description" yaml:"description"`
+}
+
+type openapiOperation struct {
+	OperationId string                      `json:"operationId,omitempty" yaml:"operationId,omitempty"`
+	Summary     string                      `json:"summary,omitempty" yaml:"summary,omitempty"`
+	Parameters  []*openapiParameter         `json:"parameters,omitempty" yaml:"parameters,omitempty"`
+	RequestBody *openapiRequestBody         `json:"requestBody,omitempty" yaml:"requestBody,omitempty"`
+	Responses   map[string]*openapiResponse `json:"responses,omitempty" yaml:"responses,omitempty"`
+	Security    []map[string][]string       `json:"security,omitempty" yaml:"security,omitempty"`
+}
+
+type openapiPathItem struct {
+	Parameters []*openapiParameter `json:"parameters,omitempty" yaml:"parameters,omitempty"`
+	Get        *openapiOperation   `json:"get,omitempty" yaml:"get,omitempty"`
+	Put        *openapiOperation   `json:"put,omitempty" yaml:"put,omitempty"`
+	Post       *openapiOperation   `json:"post,omitempty" yaml:"post,omitempty"`
+	Delete     *openapiOperation   `json:"delete,omitempty" yaml:"delete,omitempty"`
+	Options    *openapiOperation   `json:"options,omitempty" yaml:"options,omitempty"`
+	Head       *openapiOperation   `json:"head,omitempty" yaml:"head,omitempty"`
+	Patch      *openapiOperation   `json:"patch,omitempty" yaml:"patch,omitempty"`
+	Trace      *openapiOperation   `json:"trace,omitempty" yaml:"trace,omitempty"`
+}
+
+type openapiServerVariable struct {
+	Default string `json:"default" yaml:"default"`
+}
+
+type openapiServer struct {
+	Url       string                           `json:"url" yaml:"url"`
+	Variables map[string]openapiServerVariable `json:"variables,omitempty" yaml:"variables,omitempty"`
+}
+
+type openapiSecurityScheme struct {
+	Type   string `json:"type" yaml:"type"`
+	Scheme string `json:"scheme,omitempty" yaml:"scheme,omitempty"`
+	Name   string `json:"name,omitempty" yaml:"name,omitempty"`
+	In     string `json:"in,omitempty" yaml:"in,omitempty"`
+}
+
+type openapiComponents struct {
+	Schemas         map[string]*openapiSchema         `json:"schemas,omitempty" yaml:"schemas,omitempty"`
+	Parameters      map[string]*openapiParameter      `json:"parameters,omitempty" yaml:"parameters,omitempty"`
+	RequestBodies   map[string]*openapiRequestBody    `json:"requestBodies,omitempty" yaml:"requestBodies,omitempty"`
+	Examples        map[string]*openapiExample        `json:"examples,omitempty" yaml:"examples,omitempty"`
+	SecuritySchemes map[string]*openapiSecurityScheme `json:"securitySchemes,omitempty" yaml:"securitySchemes,omitempty"`
+}
+
+type openapiInfo struct {
+	Title   string `json:"title" yaml:"title"`
+	Version string `json:"version" yaml:"version"`
+}
+
+type openapiDocument struct {
+	Openapi    string                      `json:"openapi" yaml:"openapi"`
+	Info       openapiInfo                 `json:"info" yaml:"info"`
+	Servers    []openapiServer             `json:"servers,omitempty" yaml:"servers,omitempty"`
+	Paths      map[string]*openapiPathItem `json:"paths" yaml:"paths"`
+	Components *openapiComponents          `json:"components,omitempty" yaml:"components,omitempty"`
+}
+
+// the methods in the order we go through them
+var openapiMethods = []string{"GET", "PUT", "POST", "DELETE", "OPTIONS", "HEAD", "PATCH", "TRACE"}
+
+var openapiVersionRegex = regexp.MustCompile(`(?m)^\s*["']?openapi["']?\s*:\s*["']?3\.`)
+
+var openapiPathParamRegex = regexp.MustCompile(`{([^{}]+)}`)
+
+func isOpenApiSource(raw string) bool {
+	return openapiVersionRegex.MatchString(raw) || strings.Contains(removeSpaces(raw), "\"openapi\":\"3.")
+}
+
+// operations returns the operations of the path by method
+func (p *openapiPathItem) operations() map[string]*openapiOperation {
+	return map[string]*openapiOperation{
+		"GET":     p.Get,
+		"PUT":     p.Put,
+		"POST":    p.Post,
+		"DELETE":  p.Delete,
+		"OPTIONS": p.Options,
+		"HEAD":    p.Head,
+		"PATCH":   p.Patch,
+		"TRACE":   p.Trace,
+	}
+}
+
+// loadOpenApi parses an openapi 3 document, yaml is a superset of json so
+// both are handled the same
+func loadOpenApi(r io.Reader) (*openapiDocument, error) {
+	doc := &openapiDocument{}
+	if err := yaml.NewDecoder(r).Decode(doc); err != nil {
+		return nil, err
+	}
+
+	if strings.Index(doc.Openapi, "3.") != 0 {
+		return nil, errors.New("only openapi 3 documents are supported")
+	}
+
+	if doc.Components == nil {
+		doc.Components = &openapiComponents{}
+	}
+
+	return doc, nil
+}
+
+// refName returns the name of the local component reference
+// #/components/schemas/User -> User
+func refName(ref string) string {
+	return ref[strings.LastIndex(ref, "/")+1:]
+}
+
+func (doc *openapiDocument) resolveSchema(schema *openapiSchema) *openapiSchema {
+	// protect against circular references
+	for i := 0; i < 10 && schema != nil && len(schema.Ref) > 0; i++ {
+		schema = doc.Components.Schemas[refName(schema.Ref)]
+	}
+
+	return schema
+}
+
+func (doc *openapiDocument) resolveParameter(param *openapiParameter) *openapiParameter {
+	if param != nil && len(param.Ref) > 0 {
+		return doc.Components.Parameters[refName(param.Ref)]
+	}
+
+	return param
+}
+
+func (doc *openapiDocument) resolveRequestBody(body *openapiRequestBody) *openapiRequestBody {
+	if body != nil && len(body.Ref) > 0 {
+		return doc.Components.RequestBodies[refName(body.Ref)]
+	}
+
+	return body
+}
+
+func (doc *openapiDocument) resolveExample(example *openapiExample) *openapiExample {
+	if example != nil && len(example.Ref) > 0 {
+		return doc.Components.Examples[refName(example.Ref)]
+	}
+
+	return example
+}
+
+// sampleFromSchema builds a value valid for the schema, it prefers the
+// examples and defaults from the schema
+func (doc *openapiDocument) sampleFromSchema(schema *openapiSchema, depth int) interface{} {
+	schema = doc.resolveSchema(schema)
+	if schema == nil || depth > 8 {
+		return nil
+	}
+
+	if schema.Example != nil {
+		return schema.Example
+	}
+
+	if schema.Default != nil {
+		return schema.Default
+	}
+
+	if len(schema.Enum) > 0 {
+		return schema.Enum[0]
+	}
+
+	if len(schema.AllOf) > 0 {
+		merged := make(map[string]interface{})
+		for _, s := range schema.AllOf {
+			if sample, ok := doc.sampleFromSchema(s, depth+1).(map[string]interface{}); ok {
+				for k, v := range sample {
+					merged[k] = v
+				}
+			}
+		}
+
+		return merged
+	}
+
+	if len(schema.OneOf) > 0 {
+		return doc.sampleFromSchema(schema.OneOf[0], depth+1)
+	}
+
+	if len(schema.AnyOf) > 0 {
+		return doc.sampleFromSchema(schema.AnyOf[0], depth+1)
+	}
+
+	switch schema.Type {
+	case "array":
+		return []interface{}{doc.sampleFromSchema(schema.Items, depth+1)}
+	case "integer", "number":
+		if schema.Minimum != nil {
+			return *schema.Minimum
+		}
+		return 1
+	case "boolean":
+		return true
+	case "string":
+		switch schema.Format {
+		case "date-time":
+			return "2024-01-01T00:00:00Z"
+		case "date":
+			return "2024-01-01"
+		case "uuid":
+			return "3fa85f64-5717-4562-b3fc-2c963f66afa6"
+		case "email":
+			return "user@example.com"
+		case "uri", "url":
+			return "https://example.com"
+		}
+		return "string"
+	}
+
+	if schema.Type == "object" || len(schema.Properties) > 0 {
+		sample := make(map[string]interface{})
+		for k, s := range schema.Properties {
+			sample[k] = doc.sampleFromSchema(s, depth+1)
+		}
+
+		return sample
+	}
+
+	return nil
+}
+
+// parameterValue returns the value to use for the parameter
+func (doc *openapiDocument) parameterValue(param *openapiParameter) string {
+	value := param.Example
+	if value == nil {
+		for _, name := range sortedExampleKeys(param.Examples) {
+			if example := doc.resolveExample(param.Examples[name]); example != nil {
+				value = example.Value
+				break
+			}
+		}
+	}
+
+	if value == nil {
+		value = doc.sampleFromSchema(param.Schema, 0)
+	}
+
+	if value == nil {
+		return ""
+	}
+
+	return fmt.Sprintf("%v", value)
+}
+
+func sortedExampleKeys(examples map[string]*openapiExample) []string {
+	keys := []string{}
+	for k := range examples {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// requestBodySamples returns the content type and a body per example,
+// falls back to a single body built from the schema
+func (doc *openapiDocument) requestBodySamples(
+	body *openapiRequestBody,
+) (string, []interface{}) {
+	body = doc.resolveRequestBody(body)
+	if body == nil {
+		return "", []interface{}{}
+	}
+
+	// we prefer json, then forms
+	contentType := ""
+	for _, ct := range []string{"application/json", "application/x-www-form-urlencoded", "multipart/form-data"} {
+		if _, ok := body.Content[ct]; ok {
+			contentType = ct
+			break
+		}
+	}
+
+	if len(contentType) == 0 {
+		for ct := range body.Content {
+			if strings.HasSuffix(ct, "+json") {
+				contentType = ct
+				break
+			}
+		}
+	}
+
+	// any other type (text, xml, binary...), sorted so the pick is stable
+	if len(contentType) == 0 {
+		types := []string{}
+		for ct := range body.Content {
+			types = append(types, ct)
+		}
+		sort.Strings(types)
+
+		if len(types) > 0 {
+			contentType = types[0]
+		}
+	}
+
+	media, ok := body.Content[contentType]
+	if !ok || media == nil {
+		return "", []interface{}{}
+	}
+
+	if media.Example != nil {
+		return contentType, []interface{}{media.Example}
+	}
+
+	samples := []interface{}{}
+	for _, name := range sortedExampleKeys(media.Examples) {
+		if example := doc.resolveExample(media.Examples[name]); example != nil && example.Value != nil {
+			samples = append(samples, example.Value)
+		}
+	}
+
+	if len(samples) == 0 {
+		samples = append(samples, doc.sampleFromSchema(media.Schema, 0))
+	}
+
+	return contentType, samples
+}
+
+// serverBasePath returns the path of the first server, the host is left out
+// so the base url of the run decides where the requests go
+func (doc *openapiDocument) serverBasePath() string {
+	if len(doc.Servers) == 0 {
+		return ""
+	}
+
+	server := doc.Servers[0]
+	serverUrl := server.Url
+	for k, v := range server.Variables {
+		serverUrl = strings.ReplaceAll(serverUrl, "{"+k+"}", v.Default)
+	}
+
+	u, err := url.Parse(serverUrl)
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSuffix(u.Path, "/")
+}
+
+// operationParameters merges the path parameters with the operation ones,
+// the operation overrides them
+func (doc *openapiDocument) operationParameters(
+	pathItem *openapiPathItem,
+	op *openapiOperation,
+) []*openapiParameter {
+	params := []*openapiParameter{}
+	index := make(map[string]int)
+
+	for _, list := range [][]*openapiParameter{pathItem.Parameters, op.Parameters} {
+		for _, p := range list {
+			p = doc.resolveParameter(p)
+			if p == nil {
+				continue
+			}
+
+			key := p.In + ":" + p.Name
+			if i, ok := index[key]; ok {
+				params[i] = p
+				continue
+			}
+
+			index[key] = len(params)
+			params = append(params, p)
+		}
+	}
+
+	return params
+}
+
+// openApiToSources takes an openapi 3 document and generates the sources for
+// each operation, one per request body example
+func openApiToSources(r io.Reader) ([]source, error) {
+	doc, err := loadOpenApi(r)
+	if err != nil {
+		return []source{}, err
+	}
+
+	paths := []string{}
+	for p := range doc.Paths {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	basePath := doc.serverBasePath()
+	sources := []source{}
+
+	for _, path := range paths {
+		pathItem := doc.Paths[path]
+		if pathItem == nil {
+			continue
+		}
+
+		operations := pathItem.operations()
+		for _, method := range openapiMethods {
+			op := operations[method]
+			if op == nil {
+				continue
+			}
+
+			requestUrl := basePath + path
+			query := url.Values{}
+			headers := make(map[string]interface{})
+
+			for _, param := range doc.operationParameters(pathItem, op) {
+				value := doc.parameterValue(param)
+
+				switch param.In {
+				case "path":
+					requestUrl = strings.ReplaceAll(
+						requestUrl,
+						"{"+param.Name+"}",
+						url.PathEscape(value),
+					)
+					break
+				case "query":
+					// optional parameters are only sent when documented with an example
+					if param.Required || param.Example != nil || len(param.Examples) > 0 {
+						query.Set(param.Name, value)
+					}
+					break
+				case "header":
+					if param.Required || param.Example != nil {
+						headers[param.Name] = value
+					}
+					break
+				}
+			}
+
+			// undocumented path parameters still need a value
+			requestUrl = openapiPathParamRegex.ReplaceAllString(requestUrl, "1")
+			if len(query) > 0 {
+				requestUrl += "?" + query.Encode()
+			}
+
+			contentType, bodies := doc.requestBodySamples(op.RequestBody)
+			if len(contentType) > 0 {
+				headers["Content-Type"] = contentType
+			}
+
+			if len(bodies) == 0 {
+				bodies = []interface{}{nil}
+			}
+
+			for _, b := range bodies {
+				newSource := source{
+					RequestMethod:  method,
+					RequestUrl:     requestUrl,
+					RequestHeaders: headers,
+				}
+
+				newSource.RequestBody, newSource.RequestBodyEncoding = openapiSampleToBody(
+					contentType,
+					jsonCompatible(b),
+				)
+
+				sources = append(sources, newSource)
+			}
+		}
+	}
+
+	return sanitizeSources(sources), nil
+}
+
+// convertOpenApiSource takes an openapi 3 document and writes its records
+func convertOpenApiSource(r io.Reader, w *recordWriter) error {
+	data, err := openApiToSources(r)
+	if err != nil {
+		return err
+	}
+
+	return w.write(data)
+}
+
+// openapiSampleValue returns a sample as a string, for forms and texts
+func openapiSampleValue(v interface{}) string {
+	if str, ok := v.(string); ok {
+		return str
+	}
+
+	raw, _ := json.Marshal(v)
+	return string(raw)
+}
+
+// openapiSampleToBody converts a sample into the body we keep on the source,
+// the content type decides the encoding
+func openapiSampleToBody(contentType string, sample interface{}) (interface{}, string) {
+	if sample == nil {
+		return nil, ""
+	}
+
+	fields, isObject := sample.(map[string]interface{})
+
+	switch {
+	case contentType == "application/x-www-form-urlencoded" && isObject:
+		form := make(map[string]interface{})
+		for k, v := range fields {
+			form[k] = openapiSampleValue(v)
+		}
+
+		return form, capture.BodyForm
+	case contentType == "multipart/form-data" && isObject:
+		parts := []capture.BodyPart{}
+		for _, k := range sortedSampleKeys(fields) {
+			parts = append(parts, capture.BodyPart{Name: k, Value: openapiSampleValue(fields[k])})
+		}
+
+		return parts, capture.BodyMultipart
+	case contentType == "application/json" || strings.HasSuffix(contentType, "+json"):
+		return sample, capture.BodyJson
+	}
+
+	return openapiSampleValue(sample), capture.BodyText
+}
+
+// sortedSampleKeys returns the fields of a sample sorted so the output is stable
+func sortedSampleKeys(fields map[string]interface{}) []string {
+	keys := []string{}
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// jsonCompatible converts yaml decoded values so they can be json encoded
+func jsonCompatible(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[interface{}]interface{}:
+		converted := make(map[string]interface{})
+		for k, item := range value {
+			converted[fmt.Sprintf("%v", k)] = jsonCompatible(item)
+		}
+		return converted
+	case map[string]interface{}:
+		for k, item := range value {
+			value[k] = jsonCompatible(item)
+		}
+		return value
+	case []interface{}:
+		for i, item := range value {
+			value[i] = jsonCompatible(item)
+		}
+		return value
+	}
+
+	return v
+}
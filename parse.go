@@ -1,39 +1,40 @@
 package main
 
 import (
+	"bufio"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/go-redis/redis"
+
+	"request_analyser/capture"
 )
 
-type source struct {
-	Unix           int                    `json:"unix"`
-	RequestMethod  string                 `json:"requestMethod"`
-	RequestUrl     string                 `json:"requestUrl"`
-	RequestHeaders map[string]interface{} `json:"requestHeaders"`
-	RequestBody    map[string]interface{} `json:"requestBody"`
-}
+// source is a request of the records, the same type the capture package
+// writes
+type source = capture.Record
 
 func sanitizeSources(raw []source) []source {
 	newSources := []source{}
 
 	for _, v := range raw {
 		newSource := source{
-			Unix:           v.Unix,
-			RequestMethod:  strings.ToUpper(v.RequestMethod),
+			UnixMs:         v.UnixMs,
+			RequestMethod:  sourceMethod(v),
 			RequestUrl:     v.RequestUrl,
+			RequestQuery:   v.RequestQuery,
 			RequestHeaders: v.RequestHeaders,
 			RequestBody:    v.RequestBody,
-		}
 
-		if len(newSource.RequestMethod) == 0 {
-			newSource.RequestMethod = "GET"
+			RequestBodyEncoding:  v.RequestBodyEncoding,
+			RequestBodyTruncated: v.RequestBodyTruncated,
 		}
 
 		// no point in going further if we dont have a request url
@@ -45,6 +46,82 @@ func sanitizeSources(raw []source) []source {
 	return newSources
 }
 
+// sourceMethod returns the method of the request in upper case, GET when it
+// has none
+func sourceMethod(s source) string {
+	method := strings.ToUpper(s.RequestMethod)
+	if len(method) == 0 {
+		return "GET"
+	}
+
+	return method
+}
+
+// headerValues returns the values of a header, a header is a single value or
+// a list of them when it is repeated, non string values are formatted
+func headerValues(v interface{}) []string {
+	switch value := v.(type) {
+	case nil:
+		return []string{}
+	case string:
+		return []string{value}
+	case []string:
+		return value
+	case []interface{}:
+		values := []string{}
+		for _, item := range value {
+			values = append(values, headerValues(item)...)
+		}
+		return values
+	}
+
+	return []string{fmt.Sprintf("%v", v)}
+}
+
+// sourceHeader returns the first value of a header, the name is case insensitive
+func sourceHeader(headers map[string]interface{}, name string) string {
+	for k, v := range headers {
+		if !strings.EqualFold(k, name) {
+			continue
+		}
+
+		if values := headerValues(v); len(values) > 0 {
+			return values[0]
+		}
+	}
+
+	return ""
+}
+
+// addSourceHeader adds a value to a header, repeated headers are kept as a
+// list under the name they were first seen with
+func addSourceHeader(headers map[string]interface{}, name string, value string) {
+	for k, v := range headers {
+		if !strings.EqualFold(k, name) {
+			continue
+		}
+
+		list := []interface{}{}
+		for _, existing := range headerValues(v) {
+			list = append(list, existing)
+		}
+		headers[k] = append(list, value)
+
+		return
+	}
+
+	headers[name] = value
+}
+
+// sourceBody returns the raw body of the source and its content type
+func sourceBody(s source) ([]byte, string, error) {
+	return capture.DecodeBody(
+		s.RequestBody,
+		s.RequestBodyEncoding,
+		sourceHeader(s.RequestHeaders, "Content-Type"),
+	)
+}
+
 func removeSpaces(raw string) string {
 	raw = strings.ReplaceAll(raw, " ", "")
 	raw = strings.ReplaceAll(raw, "\t", "")
@@ -53,11 +130,75 @@ func removeSpaces(raw string) string {
 	return raw
 }
 
-func rawToSource(raw string) ([]source, error) {
+// legacyLineToSource converts a line on the ";;" separated format
+func legacyLineToSource(request string) (source, error) {
 	var err error
+	newSource := source{}
+
+	// separate the properties and go one by one
+	properties := strings.Split(request, ";;")
+
+	for _, property := range properties {
+		propertyData := strings.SplitN(property, ":", 2)
+		if len(propertyData) != 2 {
+			continue
+		}
+
+		value := propertyData[1]
+		k := strings.TrimSpace(strings.ToLower(propertyData[0]))
+
+		// handle the raw per key, values are different, cache them on the source
+		switch k {
+		case "time", "unix":
+			unix, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return newSource, err
+			}
+
+			newSource.UnixMs = unix * 1000
+			break
+		case "unixms":
+			newSource.UnixMs, err = strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return newSource, err
+			}
+			break
+		case "requestmethod":
+			newSource.RequestMethod = strings.ToUpper(value)
+			break
+		case "requesturl":
+			newSource.RequestUrl = value
+			break
+		case "requestheaders":
+			headers := make(map[string]interface{})
+			if err := json.Unmarshal([]byte(value), &headers); err != nil {
+				return newSource, err
+			}
+
+			newSource.RequestHeaders = headers
+			break
+		case "requestbody":
+			var body interface{}
+			if err := json.Unmarshal([]byte(value), &body); err != nil {
+				return newSource, err
+			}
+
+			newSource.RequestBody = body
+			break
+		case "requestbodyencoding":
+			newSource.RequestBodyEncoding = strings.ToLower(value)
+			break
+		}
+	}
+
+	return newSource, nil
+}
+
+// rawToSource converts the records, one per line, both the json lines and
+// the legacy ";;" format are accepted
+func rawToSource(raw string) ([]source, error) {
 	data := []source{}
 
-	lastUnix := 0
 	rawArr := strings.Split(raw, "\n")
 
 	for _, request := range rawArr {
@@ -66,168 +207,202 @@ func rawToSource(raw string) ([]source, error) {
 			continue
 		}
 
-		newSource := source{}
+		var newSource source
+		var err error
 
-		// separate the properties and go one by one
-		properties := strings.Split(request, ";;")
+		if isRecordLine(request) {
+			var ok bool
+			newSource, ok, err = recordLineToSource(request)
+			if err != nil {
+				return data, err
+			}
 
-		for _, property := range properties {
-			propertyData := strings.SplitN(property, ":", 2)
-			if len(propertyData) != 2 {
+			// the header line has no request
+			if !ok {
 				continue
 			}
-
-			value := propertyData[1]
-			k := strings.TrimSpace(strings.ToLower(propertyData[0]))
-
-			// handle the raw per key, values are different, cache them on the source
-			switch k {
-			case "time":
-				newSource.Unix, err = strconv.Atoi(value)
-				if err != nil {
-					return data, err
-				}
-				break
-			case "requestmethod":
-				newSource.RequestMethod = strings.ToUpper(value)
-				break
-			case "requesturl":
-				newSource.RequestUrl = value
-				break
-			case "requestheaders":
-				headers := make(map[string]interface{})
-				if err := json.Unmarshal([]byte(value), &headers); err != nil {
-					return data, err
-				}
-
-				newSource.RequestHeaders = headers
-				break
-			case "requestbody":
-				body := make(map[string]interface{})
-				if err := json.Unmarshal([]byte(value), &body); err != nil {
-					return data, err
-				}
-
-				newSource.RequestBody = body
-				break
+		} else {
+			newSource, err = legacyLineToSource(request)
+			if err != nil {
+				return data, err
 			}
 		}
 
-		// make sure all requests have unix, the 0 won't help us down the road
-		newSource.Unix = int(time.Now().Unix())
-		// shouldn't be this fast but better safe than sorry
-		if newSource.Unix == lastUnix {
-			newSource.Unix += 1
-		}
-		lastUnix = newSource.Unix
-
 		// no point in going further if we dont have a request url
 		if len(newSource.RequestUrl) > 0 {
 			data = append(data, newSource)
 		}
 	}
 
+	// the records are read as they are, the ones without a time stay untimed,
+	// the ones without a method are GET as when they are run
+	for i := range data {
+		data[i] = splitSourceQuery(data[i])
+		data[i].RequestMethod = sourceMethod(data[i])
+	}
+
 	return data, nil
 }
 
+// normalizeSources makes sure the sources parse writes have a method, a time
+// and their query apart from the url
+func normalizeSources(data []source) []source {
+	for i := range data {
+		data[i] = splitSourceQuery(data[i])
+		data[i].RequestMethod = sourceMethod(data[i])
+	}
+
+	return stampSources(data)
+}
+
+// stampSources makes sure all the sources have a time, the 0 won't help us
+// down the road, the ones with a time keep it
+func stampSources(data []source) []source {
+	lastUnixMs := int64(0)
+
+	for i := range data {
+		if data[i].UnixMs > 0 {
+			continue
+		}
+
+		data[i].UnixMs = time.Now().UnixMilli()
+		// shouldn't be this fast but better safe than sorry
+		if data[i].UnixMs <= lastUnixMs {
+			data[i].UnixMs = lastUnixMs + 1
+		}
+		lastUnixMs = data[i].UnixMs
+	}
+
+	return data
+}
+
 func isRawSource(raw string) bool {
 	return strings.Contains(strings.ToLower(raw), "requesturl") && strings.Contains(raw, ";") &&
 		strings.Contains(raw, ":")
 }
 
+// isJsonSingleSource checks if the source starts a json object, only the
+// start of the source is needed
 func isJsonSingleSource(raw string) bool {
-	return strings.Index(raw, "{") == 0 && strings.LastIndex(raw, "}") == len(raw)-1
+	return strings.Index(raw, "{") == 0
 }
 
+// isJsonSource checks if the source starts a json array
 func isJsonSource(raw string) bool {
-	return strings.Index(raw, "[") == 0 && strings.LastIndex(raw, "]") == len(raw)-1
+	return strings.Index(raw, "[") == 0
 }
 
 func isRedisSource(raw string) bool {
 	return strings.Index(raw, "redis://") == 0 || strings.Index(raw, "rediss://") == 0
 }
 
-// convertRawSource takes a raw string and saves it to a file to be used
-// the source array we use on the tool
-func convertRawSource(raw string, filePath string) error {
-	// time to append to file
-	f, err := os.OpenFile(filePath,
-		os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	defer f.Close()
+// convertRawSource reads records, on the json lines or the legacy format, one
+// line at a time and writes them on the current format
+func convertRawSource(r io.Reader, w *recordWriter) error {
+	return readLines(r, func(line string) error {
+		data, err := rawToSource(line)
+		if err != nil {
+			return err
+		}
+
+		return w.write(data)
+	})
+}
+
+// convertJsonSource reads an array of json sources, decoded one at a time
+func convertJsonSource(r io.Reader, w *recordWriter) error {
+	dec := json.NewDecoder(r)
+
+	token, err := dec.Token()
 	if err != nil {
 		return err
 	}
 
-	if _, err := f.WriteString(raw); err != nil {
-		return err
+	if token != json.Delim('[') {
+		return errors.New("json sources have to be an array of objects")
+	}
+
+	for dec.More() {
+		var s source
+		if err := dec.Decode(&s); err != nil {
+			return err
+		}
+
+		if err := w.write([]source{s}); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
-// convertJsonSource takes a valid json string and converts it to a file
-// that the software know how to use
-func convertJsonSource(raw []byte, filePath string) error {
-	// normalize the single format
-	if isJsonSingleSource(string(raw)) {
-		raw = []byte("[" + string(raw) + "]")
-	}
+// convertRedisSource takes a redis url and fetches all keys with a pattern,
+// or the items of a list, stream or hash, and then converts to a source
+// array we use on the tool
+func convertRedisSource(srcRaw string, w *recordWriter) error {
+	src := parseRedisSource(srcRaw)
 
-	// handle the array format
-	var data []source
-	if err := json.Unmarshal(raw, &data); err != nil {
+	opts, err := redis.ParseURL(src.url)
+	if err != nil {
 		return err
 	}
 
-	// time to convert and send to a file
-	newRaw := ""
-	for _, req := range data {
-		headers, err := json.Marshal(req.RequestHeaders)
-		if err != nil {
-			return err
+	rdb := redis.NewClient(opts)
+	defer rdb.Close()
+
+	writer := func(raw string) error {
+		if len(raw) == 0 {
+			return nil
 		}
 
-		body, err := json.Marshal(req.RequestBody)
+		data, err := rawToSource(raw)
 		if err != nil {
 			return err
 		}
 
-		newRaw += fmt.Sprintf(
-			"unix:%d;;requestUrl:%s;;requestMethod:%s;;requestHeaders:%s;;requestBody:%s\n",
-			req.Unix,
-			req.RequestUrl,
-			req.RequestMethod,
-			headers,
-			body,
-		)
+		return w.write(data)
 	}
 
-	return convertRawSource(newRaw, filePath)
-}
+	switch src.mode {
+	case "list":
+		return redisReadList(rdb, src.key, writer)
+	case "stream":
+		start := "-"
+		end := "+"
+		if len(src.args) > 0 && len(src.args[0]) > 0 {
+			start = src.args[0]
+		}
+		if len(src.args) > 1 && len(src.args[1]) > 0 {
+			end = src.args[1]
+		}
 
-// parseRedisSource takes a redis url and fetches all keys with a pattern
-// and then converts to a source array we use on the tool
-func convertRedisSource(srcRaw string, filePath string) error {
-	arr := strings.Split(srcRaw, ";")
-	pattern := "*"
-	if len(arr) == 2 {
-		pattern = arr[1]
-	}
+		return redisReadStream(rdb, src.key, start, end, writer)
+	case "hash":
+		fields := []string{}
+		if len(src.args) > 0 && len(src.args[0]) > 0 {
+			fields = strings.Split(src.args[0], ",")
+		}
 
-	opts, err := redis.ParseURL(arr[0])
-	if err != nil {
-		return err
+		return redisReadHash(rdb, src.key, fields, writer)
+	case "channel":
+		return errors.New("redis channels have no history to parse, use run to tail them")
 	}
 
-	rdb := redis.NewClient(opts)
-	return redisScanAll(rdb, pattern, func(raw string) error {
-		return convertRawSource(raw, filePath)
-	})
+	return redisScanAll(rdb, src.key, writer)
 }
 
+// sourcePeekSize is the size of the start of a source used to detect its
+// format, the rest of it is streamed
+const sourcePeekSize = 1 << 20
+
 // sourceToFilePath takes a string, makes sure it is on the raw format we are
 // expecting on the tool and saves to a file path, returns that file path
-func sourceToFilePath(srcRaw string, outputPath string) error {
+func sourceToFilePath(
+	srcRaw string,
+	outputPath string,
+	logFormat string,
+	envPath string,
+) error {
 	if len(srcRaw) == 0 {
 		return errors.New("source is required")
 	}
@@ -236,28 +411,138 @@ func sourceToFilePath(srcRaw string, outputPath string) error {
 		return errors.New("output path is required")
 	}
 
-	// remove spaces so we can easily check for indexes
-	noSpacesRaw := removeSpaces(srcRaw)
+	// the source might be the stdin or a path to a file, read its content then
+	var r io.Reader = strings.NewReader(srcRaw)
+	if info, err := os.Stat(srcRaw); srcRaw == stdinPath || (err == nil && !info.IsDir()) {
+		input, err := openInput(srcRaw)
+		if err != nil {
+			return err
+		}
+		defer input.Close()
 
-	if isJsonSource(noSpacesRaw) || isJsonSingleSource(noSpacesRaw) {
-		err := convertJsonSource([]byte(srcRaw), outputPath)
-		return err
+		r = input
+	} else if isRedisSource(removeSpaces(srcRaw)) {
+		return checkRecordsFile(writeRecordsFile(outputPath, func(w *recordWriter) error {
+			return convertRedisSource(srcRaw, w)
+		}))
 	}
 
-	if isRedisSource(noSpacesRaw) {
-		err := convertRedisSource(srcRaw, outputPath)
+	reader := bufio.NewReaderSize(r, sourcePeekSize)
+	peeked, err := reader.Peek(sourcePeekSize)
+	if err != nil && err != io.EOF {
 		return err
 	}
 
-	if isRawSource(noSpacesRaw) {
-		err := convertRawSource(srcRaw, outputPath)
+	// the start of the source tells its format, remove spaces so we can easily
+	// check for indexes
+	head := string(peeked)
+	noSpacesHead := removeSpaces(head)
+
+	return checkRecordsFile(writeRecordsFile(outputPath, func(w *recordWriter) error {
+		// openapi, postman and har are json objects as well, they need to be checked first
+		if isOpenApiSource(head) {
+			return convertOpenApiSource(reader, w)
+		}
+
+		if isPostmanSource(noSpacesHead) {
+			return convertPostmanSource(reader, envPath, w)
+		}
+
+		if isHarSource(noSpacesHead) {
+			return convertHarSource(reader, w)
+		}
+
+		// parsed records are json objects as well, they need to be checked
+		// before the json sources
+		if isRecordSource(head) {
+			return convertRawSource(reader, w)
+		}
+
+		if isJsonSource(noSpacesHead) {
+			return convertJsonSource(reader, w)
+		}
+
+		// normalize the single format
+		if isJsonSingleSource(noSpacesHead) {
+			array := io.MultiReader(strings.NewReader("["), reader, strings.NewReader("]"))
+			return convertJsonSource(array, w)
+		}
+
+		if isCurlSource(head) {
+			return convertCurlSource(reader, w)
+		}
+
+		if isRawSource(noSpacesHead) {
+			return convertRawSource(reader, w)
+		}
+
+		// a log format means the source has to be an access log
+		if len(logFormat) > 0 || isAccessLogSource(head) {
+			return convertAccessLogSource(reader, logFormat, w)
+		}
+
+		return errors.New("source format not supported")
+	}))
+}
+
+// checkRecordsFile makes sure a source had records, a source without any
+// is likely one whose format wasn't detected
+func checkRecordsFile(count int, err error) error {
+	if err == nil && count == 0 {
+		return errors.New("no records found on the source")
+	}
+
+	return err
+}
+
+// mergeSources converts each of the sources on its own, sorts its records by
+// time and then merges all the records into the output in timestamp order
+func mergeSources(
+	sources []string,
+	outputPath string,
+	logFormat string,
+	envPath string,
+) error {
+	tmpDir, err := os.MkdirTemp("", "request_analyser")
+	if err != nil {
 		return err
 	}
+	defer os.RemoveAll(tmpDir)
+
+	tmpPaths := []string{}
+	for i, src := range sources {
+		tmpPath := filepath.Join(tmpDir, strconv.Itoa(i))
+		err := sourceToFilePath(src, tmpPath, logFormat, envPath)
+		if err == nil {
+			// the merge needs each of the inputs sorted
+			err = sortRecordsFile(tmpPath)
+		}
 
-	return nil
+		// the source is told apart when there are several
+		if err != nil && len(sources) > 1 {
+			return fmt.Errorf("%s: %w", src, err)
+		}
+		if err != nil {
+			return err
+		}
+		tmpPaths = append(tmpPaths, tmpPath)
+	}
+
+	return writeRecords(tmpPaths, outputPath)
 }
 
-// parse checks the general statistics
-func parse(srcRaw string, outputPath string) error {
-	return sourceToFilePath(srcRaw, outputPath)
+// parse converts the sources to records, the records of each parse are
+// written in timestamp order
+func parse(srcRaw string, outputPath string, logFormat string, envPath string) error {
+	if len(outputPath) == 0 {
+		return errors.New("output path is required")
+	}
+
+	// redis urls and sources that are not paths are used as they are
+	paths, err := inputPaths(srcRaw)
+	if isRedisSource(removeSpaces(srcRaw)) || err != nil {
+		paths = []string{srcRaw}
+	}
+
+	return mergeSources(paths, outputPath, logFormat, envPath)
 }
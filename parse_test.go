@@ -0,0 +1,44 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseSortsRecordsByTime(t *testing.T) {
+	dir := t.TempDir()
+	sourcePath := filepath.Join(dir, "requests.json")
+	source := `[
+		{"requestUrl": "/third", "unixMs": 3000},
+		{"requestUrl": "/first", "unixMs": 1000},
+		{"requestUrl": "/second", "unixMs": 2000}
+	]`
+	if err := os.WriteFile(sourcePath, []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	outputPath := filepath.Join(dir, "records")
+	if err := parse(sourcePath, outputPath, "", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	urls := []string{}
+	for _, line := range strings.Split(strings.TrimSpace(string(raw)), "\n")[1:] {
+		s, _, err := recordLineToSource(line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		urls = append(urls, s.RequestUrl)
+	}
+
+	if strings.Join(urls, " ") != "/first /second /third" {
+		t.Errorf("records written as %v, want them sorted by time", urls)
+	}
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+
+	"request_analyser/capture"
+)
+
+type postmanKeyValue struct {
+	Key      string      `json:"key"`
+	Value    interface{} `json:"value"`
+	Type     string      `json:"type"`
+	Disabled bool        `json:"disabled"`
+	// environments use enabled instead of disabled
+	Enabled *bool `json:"enabled"`
+}
+
+type postmanUrl struct {
+	Raw      string            `json:"raw"`
+	Variable []postmanKeyValue `json:"variable"`
+}
+
+type postmanBody struct {
+	Mode       string            `json:"mode"`
+	Raw        string            `json:"raw"`
+	Urlencoded []postmanKeyValue `json:"urlencoded"`
+	Formdata   []postmanKeyValue `json:"formdata"`
+	Options    struct {
+		Raw struct {
+			Language string `json:"language"`
+		} `json:"raw"`
+	} `json:"options"`
+}
+
+// content types of the raw body languages
+var postmanLanguageContentTypes = map[string]string{
+	"json":       "application/json",
+	"xml":        "application/xml",
+	"html":       "text/html",
+	"javascript": "application/javascript",
+	"text":       "text/plain",
+}
+
+type postmanAuth struct {
+	Type   string            `json:"type"`
+	Bearer []postmanKeyValue `json:"bearer"`
+	Basic  []postmanKeyValue `json:"basic"`
+}
+
+type postmanRequest struct {
+	Method string            `json:"method"`
+	Header []postmanKeyValue `json:"header"`
+	Url    json.RawMessage   `json:"url"`
+	Body   *postmanBody      `json:"body"`
+	Auth   *postmanAuth      `json:"auth"`
+}
+
+type postmanItem struct {
+	Name    string          `json:"name"`
+	Item    []postmanItem   `json:"item"`
+	Request json.RawMessage `json:"request"`
+	Auth    *postmanAuth    `json:"auth"`
+}
+
+type postmanCollection struct {
+	Info struct {
+		Name   string `json:"name"`
+		Schema string `json:"schema"`
+	} `json:"info"`
+	Item     []postmanItem     `json:"item"`
+	Variable []postmanKeyValue `json:"variable"`
+	Auth     *postmanAuth      `json:"auth"`
+}
+
+type postmanEnvironment struct {
+	Values []postmanKeyValue `json:"values"`
+}
+
+var postmanVariableRegex = regexp.MustCompile(`{{\s*([^{}]+?)\s*}}`)
+
+func isPostmanSource(raw string) bool {
+	return isJsonSingleSource(raw) && strings.Contains(raw, "\"info\":{") &&
+		(strings.Contains(raw, "schema.getpostman.com") || strings.Contains(raw, "\"_postman_id\""))
+}
+
+// isEnabled checks if the key value wasn't disabled by the user
+func (kv postmanKeyValue) isEnabled() bool {
+	return !kv.Disabled && (kv.Enabled == nil || *kv.Enabled)
+}
+
+// stringValue returns the value as a string, postman allows other types on values
+func (kv postmanKeyValue) stringValue() string {
+	switch v := kv.Value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	default:
+		raw, _ := json.Marshal(v)
+		return string(raw)
+	}
+}
+
+// resolvePostmanVariables replaces the {{variables}} on a string, unknown
+// variables are kept as they are
+func resolvePostmanVariables(raw string, variables map[string]string) string {
+	// variables may reference other variables, do a few passes
+	for i := 0; i < 5 && postmanVariableRegex.MatchString(raw); i++ {
+		resolved := postmanVariableRegex.ReplaceAllStringFunc(raw, func(match string) string {
+			name := postmanVariableRegex.FindStringSubmatch(match)[1]
+			if v, ok := variables[name]; ok {
+				return v
+			}
+
+			return match
+		})
+
+		if resolved == raw {
+			break
+		}
+		raw = resolved
+	}
+
+	return raw
+}
+
+// postmanUrlToString converts the url, it may be a string or an object
+func postmanUrlToString(raw json.RawMessage) string {
+	var rawUrl string
+	if err := json.Unmarshal(raw, &rawUrl); err == nil {
+		return rawUrl
+	}
+
+	var u postmanUrl
+	if err := json.Unmarshal(raw, &u); err != nil {
+		return ""
+	}
+
+	// path variables as /users/:id
+	for _, v := range u.Variable {
+		if len(v.Key) > 0 && v.Value != nil {
+			u.Raw = regexp.MustCompile(`/:`+regexp.QuoteMeta(v.Key)+`\b`).
+				ReplaceAllLiteralString(u.Raw, "/"+v.stringValue())
+		}
+	}
+
+	return u.Raw
+}
+
+// postmanBodyToBody converts the body into the body we keep on the source,
+// raw bodies use the content type header or their language
+func postmanBodyToBody(
+	body *postmanBody,
+	contentType string,
+	variables map[string]string,
+) (interface{}, string) {
+	if body == nil {
+		return nil, ""
+	}
+
+	switch body.Mode {
+	case "raw":
+		if len(contentType) == 0 {
+			contentType = postmanLanguageContentTypes[body.Options.Raw.Language]
+		}
+
+		raw := resolvePostmanVariables(body.Raw, variables)
+		return capture.EncodeBody(contentType, []byte(raw))
+	case "urlencoded":
+		values := url.Values{}
+		for _, kv := range body.Urlencoded {
+			if kv.isEnabled() {
+				values.Add(kv.Key, resolvePostmanVariables(kv.stringValue(), variables))
+			}
+		}
+
+		if len(values) == 0 {
+			return nil, ""
+		}
+
+		return capture.EncodeBody("application/x-www-form-urlencoded", []byte(values.Encode()))
+	case "formdata":
+		parts := []capture.BodyPart{}
+		for _, kv := range body.Formdata {
+			// files aren't something we are able to send
+			if kv.isEnabled() && kv.Type != "file" {
+				parts = append(parts, capture.BodyPart{
+					Name:  kv.Key,
+					Value: resolvePostmanVariables(kv.stringValue(), variables),
+				})
+			}
+		}
+
+		if len(parts) == 0 {
+			return nil, ""
+		}
+
+		return parts, capture.BodyMultipart
+	}
+
+	return nil, ""
+}
+
+// postmanAuthToHeader converts the supported auth types into a header value
+func postmanAuthToHeader(auth *postmanAuth, variables map[string]string) string {
+	if auth == nil {
+		return ""
+	}
+
+	values := make(map[string]string)
+	list := auth.Bearer
+	if auth.Type == "basic" {
+		list = auth.Basic
+	}
+
+	for _, kv := range list {
+		values[kv.Key] = resolvePostmanVariables(kv.stringValue(), variables)
+	}
+
+	switch auth.Type {
+	case "bearer":
+		return "Bearer " + values["token"]
+	case "basic":
+		credentials := values["username"] + ":" + values["password"]
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(credentials))
+	}
+
+	return ""
+}
+
+// postmanItemsToSources walks the items recursively, folders inherit the auth
+func postmanItemsToSources(
+	items []postmanItem,
+	auth *postmanAuth,
+	variables map[string]string,
+) []source {
+	sources := []source{}
+
+	for _, item := range items {
+		itemAuth := auth
+		if item.Auth != nil {
+			itemAuth = item.Auth
+		}
+
+		// a folder
+		if len(item.Request) == 0 {
+			sources = append(sources, postmanItemsToSources(item.Item, itemAuth, variables)...)
+			continue
+		}
+
+		// the request may be just the url
+		request := postmanRequest{Url: item.Request}
+		if err := json.Unmarshal(item.Request, &request); err != nil {
+			request = postmanRequest{Url: item.Request}
+		}
+
+		newSource := source{
+			RequestMethod:  request.Method,
+			RequestUrl:     resolvePostmanVariables(postmanUrlToString(request.Url), variables),
+			RequestHeaders: make(map[string]interface{}),
+		}
+
+		if request.Auth != nil {
+			itemAuth = request.Auth
+		}
+
+		for _, kv := range request.Header {
+			if kv.isEnabled() {
+				addSourceHeader(
+					newSource.RequestHeaders,
+					kv.Key,
+					resolvePostmanVariables(kv.stringValue(), variables),
+				)
+			}
+		}
+
+		// an authorization header on the request overrides the auth
+		header := postmanAuthToHeader(itemAuth, variables)
+		if len(header) > 0 && len(sourceHeader(newSource.RequestHeaders, "Authorization")) == 0 {
+			newSource.RequestHeaders["Authorization"] = header
+		}
+
+		newSource.RequestBody, newSource.RequestBodyEncoding = postmanBodyToBody(
+			request.Body,
+			sourceHeader(newSource.RequestHeaders, "Content-Type"),
+			variables,
+		)
+
+		sources = append(sources, newSource)
+	}
+
+	return sources
+}
+
+// postmanToSources takes a postman v2.1 collection and converts each request
+// to the source we use on the tool, the environment is optional
+func postmanToSources(r io.Reader, envPath string) ([]source, error) {
+	var collection postmanCollection
+	if err := json.NewDecoder(r).Decode(&collection); err != nil {
+		return []source{}, err
+	}
+
+	// the environment overrides the collection variables
+	variables := make(map[string]string)
+	for _, kv := range collection.Variable {
+		if kv.isEnabled() {
+			variables[kv.Key] = kv.stringValue()
+		}
+	}
+
+	if len(envPath) > 0 {
+		envRaw, err := os.ReadFile(envPath)
+		if err != nil {
+			return []source{}, err
+		}
+
+		var env postmanEnvironment
+		if err := json.Unmarshal(envRaw, &env); err != nil {
+			return []source{}, err
+		}
+
+		for _, kv := range env.Values {
+			if kv.isEnabled() {
+				variables[kv.Key] = kv.stringValue()
+			}
+		}
+	}
+
+	sources := postmanItemsToSources(collection.Item, collection.Auth, variables)
+
+	return sanitizeSources(sources), nil
+}
+
+// convertPostmanSource takes a postman collection and writes its records
+func convertPostmanSource(r io.Reader, envPath string, w *recordWriter) error {
+	data, err := postmanToSources(r, envPath)
+	if err != nil {
+		return err
+	}
+
+	return w.write(data)
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+
+	"request_analyser/capture"
+)
+
+// splitSourceQuery moves the query of the request url to the request query,
+// parameters already on the request query are kept
+func splitSourceQuery(s source) source {
+	i := strings.Index(s.RequestUrl, "?")
+	if i < 0 {
+		return s
+	}
+
+	rawQuery := s.RequestUrl[i+1:]
+	s.RequestUrl = s.RequestUrl[:i]
+
+	values, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		// not something we can parse, keep it as it came
+		s.RequestUrl += "?" + rawQuery
+		return s
+	}
+
+	for k, v := range sourceQueryValues(s) {
+		values[k] = append(values[k], v...)
+	}
+
+	if len(values) > 0 {
+		s.RequestQuery = capture.FormToBody(values)
+	}
+
+	return s
+}
+
+// sourceQueryValues returns the parameters of the request query, the values
+// are read the same way as the form bodies
+func sourceQueryValues(s source) url.Values {
+	values, err := capture.BodyToForm(s.RequestQuery)
+	if err != nil {
+		return url.Values{}
+	}
+
+	return values
+}
+
+// sourceRequestUrl returns the request url with the query back on it, the
+// parameters are encoded and sorted by name
+func sourceRequestUrl(s source) string {
+	if len(s.RequestQuery) == 0 {
+		return s.RequestUrl
+	}
+
+	separator := "?"
+	if strings.Contains(s.RequestUrl, "?") {
+		separator = "&"
+	}
+
+	return s.RequestUrl + separator + sourceQueryValues(s).Encode()
+}
+
+// paramValuesLimit caps the distinct values kept per parameter, ids and
+// tokens would fill the memory otherwise
+const paramValuesLimit = 1000
+
+// paramStat is the usage of a query parameter on a request
+type paramStat struct {
+	name  string
+	count int
+	// values counts the requests per value, up to the limit
+	values map[string]int
+	// overflow is set when there were more values than the limit
+	overflow bool
+	// repeated is set when a request had more than one value
+	repeated bool
+}
+
+// add counts the values of the parameter on a request
+func (p *paramStat) add(values []string) {
+	p.count += 1
+	p.repeated = p.repeated || len(values) > 1
+
+	for _, v := range values {
+		if _, ok := p.values[v]; !ok && len(p.values) >= paramValuesLimit {
+			p.overflow = true
+			continue
+		}
+
+		p.values[v] += 1
+	}
+}
+
+// merge adds the usage of the same parameter on another request
+func (p *paramStat) merge(other *paramStat) {
+	p.count += other.count
+	p.overflow = p.overflow || other.overflow
+	p.repeated = p.repeated || other.repeated
+
+	for v, count := range other.values {
+		if _, ok := p.values[v]; !ok && len(p.values) >= paramValuesLimit {
+			p.overflow = true
+			continue
+		}
+
+		p.values[v] += count
+	}
+}
+
+// topValues returns the most used values, up to the limit
+func (p *paramStat) topValues(limit int) []string {
+	values := []string{}
+	for v := range p.values {
+		values = append(values, v)
+	}
+
+	sort.Slice(values, func(i, j int) bool {
+		if p.values[values[i]] == p.values[values[j]] {
+			return values[i] < values[j]
+		}
+
+		return p.values[values[i]] > p.values[values[j]]
+	})
+
+	if len(values) > limit {
+		values = values[:limit]
+	}
+
+	return values
+}
+
+// sortedParams returns the parameters of a request sorted by name
+func sortedParams(params map[string]*paramStat) []*paramStat {
+	list := []*paramStat{}
+	for _, p := range params {
+		list = append(list, p)
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].name < list[j].name })
+
+	return list
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"request_analyser/capture"
+)
+
+// newRecordHandler returns a reverse proxy to the target that appends every
+// request to the writer before forwarding it
+func newRecordHandler(target string, writer capture.Writer) (http.Handler, error) {
+	if len(target) == 0 {
+		return nil, errors.New("target is required")
+	}
+
+	targetUrl, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+
+	proxy := httputil.NewSingleHostReverseProxy(targetUrl)
+
+	// the target may check the host, make sure it is the one it expects
+	director := proxy.Director
+	proxy.Director = func(r *http.Request) {
+		director(r)
+		r.Host = targetUrl.Host
+	}
+
+	return capture.Handler(proxy, writer), nil
+}
+
+// record starts a reverse proxy in front of the target, recording the requests
+// until interrupted
+func record(target string, address string, outputPath string) error {
+	if len(outputPath) == 0 {
+		return errors.New("output path is required")
+	}
+
+	writer := capture.NewFileWriter(outputPath)
+	handler, err := newRecordHandler(target, writer)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	server := &http.Server{Addr: address, Handler: handler}
+	served := make(chan error, 1)
+	go func() {
+		served <- server.ListenAndServe()
+	}()
+
+	log.Println("recording requests to", target, "on", address)
+
+	select {
+	case err = <-served:
+		break
+	case <-ctx.Done():
+		// let the requests in flight be recorded before closing the output
+		err = server.Shutdown(context.Background())
+		break
+	}
+
+	if closeErr := writer.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"request_analyser/capture"
+)
+
+func TestRecordProxyForwardsAndRecords(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		w.Header().Set("X-Target", "yes")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(r.Method + " " + r.URL.RequestURI() + " " + string(body)))
+	}))
+	defer target.Close()
+
+	outputPath := filepath.Join(t.TempDir(), "records")
+	writer := capture.NewFileWriter(outputPath)
+
+	handler, err := newRecordHandler(target.URL, writer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := httptest.NewServer(handler)
+	defer proxy.Close()
+
+	res, err := http.Get(proxy.URL + "/users/1?full=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+
+	if string(body) != "GET /users/1?full=true " || res.StatusCode != http.StatusCreated || res.Header.Get("X-Target") != "yes" {
+		t.Errorf("forwarded %d %q x-target %q", res.StatusCode, body, res.Header.Get("X-Target"))
+	}
+
+	res, err = http.Post(proxy.URL+"/users", "application/json", strings.NewReader(`{"name":"amazing"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = io.ReadAll(res.Body)
+	res.Body.Close()
+
+	if string(body) != `POST /users {"name":"amazing"}` {
+		t.Errorf("forwarded %q", body)
+	}
+
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(raw)), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("got %d lines, want the header and 2 records:\n%s", len(lines), raw)
+	}
+
+	if lines[0] != capture.HeaderLine() {
+		t.Errorf("header %q, want %q", lines[0], capture.HeaderLine())
+	}
+
+	records := []source{}
+	for _, line := range lines[1:] {
+		var s source
+		if err := json.Unmarshal([]byte(line), &s); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, s)
+	}
+
+	if records[0].RequestMethod != "GET" || records[0].RequestUrl != "/users/1" || records[0].RequestQuery["full"] != "true" {
+		t.Errorf("first record %+v", records[0])
+	}
+
+	if records[1].RequestMethod != "POST" || records[1].RequestUrl != "/users" || records[1].RequestBodyEncoding != capture.BodyJson {
+		t.Errorf("second record %+v", records[1])
+	}
+	if body, ok := records[1].RequestBody.(map[string]interface{}); !ok || body["name"] != "amazing" {
+		t.Errorf("second record body %v", records[1].RequestBody)
+	}
+	if records[1].RequestHeaders["Content-Type"] != "application/json" {
+		t.Errorf("second record headers %v", records[1].RequestHeaders)
+	}
+}
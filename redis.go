@@ -1,7 +1,10 @@
 package main
 
 import (
+	"fmt"
 	"reflect"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/go-redis/redis"
@@ -100,3 +103,187 @@ func redisScanAll(
 
 	return nil
 }
+
+// redisSource is a redis source string split into its parts
+// redis://url;<pattern>, redis://url;list:<key>, redis://url;hash:<key>;<fields>
+// redis://url;stream:<key>;<start>;<end> or redis://url;channel:<channel>
+type redisSource struct {
+	url  string
+	mode string
+	key  string
+	args []string
+}
+
+// parseRedisSource splits the redis source string, a plain pattern is a scan
+func parseRedisSource(srcRaw string) redisSource {
+	arr := strings.Split(strings.TrimSpace(srcRaw), ";")
+	src := redisSource{url: arr[0], mode: "scan", key: "*", args: []string{}}
+
+	if len(arr) < 2 || len(arr[1]) == 0 {
+		return src
+	}
+
+	src.key = arr[1]
+	src.args = arr[2:]
+
+	for _, mode := range []string{"list", "stream", "hash", "channel"} {
+		if strings.Index(arr[1], mode+":") == 0 {
+			src.mode = mode
+			src.key = strings.TrimPrefix(arr[1], mode+":")
+			break
+		}
+	}
+
+	return src
+}
+
+// redisReadList fetches all the items of a list with pagination
+func redisReadList(
+	rdb *redis.Client,
+	key string,
+	writer func(raw string) error,
+) error {
+	limit := int64(1000)
+
+	for start := int64(0); ; start += limit {
+		vals, err := rdb.LRange(key, start, start+limit-1).Result()
+		if err != nil {
+			return err
+		}
+
+		if len(vals) > 0 {
+			if err := writer(strings.Join(vals, "\n")); err != nil {
+				return err
+			}
+		}
+
+		if int64(len(vals)) < limit {
+			return nil
+		}
+	}
+}
+
+// redisStreamMessageToRaw returns the record on a stream entry, the "record"
+// field is used when there is one, if not all the string values are
+func redisStreamMessageToRaw(msg redis.XMessage) string {
+	if v, ok := msg.Values["record"].(string); ok {
+		return v
+	}
+
+	fields := []string{}
+	for k := range msg.Values {
+		fields = append(fields, k)
+	}
+	sort.Strings(fields)
+
+	vals := []string{}
+	for _, k := range fields {
+		if v, ok := msg.Values[k].(string); ok {
+			vals = append(vals, v)
+		}
+	}
+
+	return strings.Join(vals, "\n")
+}
+
+// redisNextStreamId returns the id right after the one provided so the
+// pagination doesn't fetch the same entry twice
+func redisNextStreamId(id string) string {
+	arr := strings.SplitN(id, "-", 2)
+	if len(arr) != 2 {
+		return id
+	}
+
+	seq, err := strconv.ParseUint(arr[1], 10, 64)
+	if err != nil {
+		return id
+	}
+
+	return fmt.Sprintf("%s-%d", arr[0], seq+1)
+}
+
+// redisReadStream fetches the entries of a stream between two ids with
+// pagination, "-" and "+" are the first and last entries
+func redisReadStream(
+	rdb *redis.Client,
+	key string,
+	start string,
+	end string,
+	writer func(raw string) error,
+) error {
+	limit := int64(1000)
+
+	for {
+		msgs, err := rdb.XRangeN(key, start, end, limit).Result()
+		if err != nil {
+			return err
+		}
+
+		vals := []string{}
+		for _, msg := range msgs {
+			vals = append(vals, redisStreamMessageToRaw(msg))
+		}
+
+		if len(vals) > 0 {
+			if err := writer(strings.Join(vals, "\n")); err != nil {
+				return err
+			}
+		}
+
+		if int64(len(msgs)) < limit {
+			return nil
+		}
+
+		start = redisNextStreamId(msgs[len(msgs)-1].ID)
+	}
+}
+
+// redisReadHash fetches the values of a hash, all of them unless
+// specific fields are provided
+func redisReadHash(
+	rdb *redis.Client,
+	key string,
+	fields []string,
+	writer func(raw string) error,
+) error {
+	if len(fields) > 0 {
+		valsRaw, err := rdb.HMGet(key, fields...).Result()
+		if err != nil {
+			return err
+		}
+
+		vals := []string{}
+		for _, v := range valsRaw {
+			if v != nil && reflect.TypeOf(v).String() == "string" {
+				vals = append(vals, v.(string))
+			}
+		}
+
+		return writer(strings.Join(vals, "\n"))
+	}
+
+	cursor := uint64(0)
+	for {
+		// the result comes as field, value, field, value...
+		result, nextCursor, err := rdb.HScan(key, cursor, "*", 1000).Result()
+		if err != nil {
+			return err
+		}
+
+		vals := []string{}
+		for i := 1; i < len(result); i += 2 {
+			vals = append(vals, result[i])
+		}
+
+		if len(vals) > 0 {
+			if err := writer(strings.Join(vals, "\n")); err != nil {
+				return err
+			}
+		}
+
+		if nextCursor == 0 {
+			return nil
+		}
+		cursor = nextCursor
+	}
+}
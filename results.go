@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// runResultColumns are the columns of the run results csv, in order
+var runResultColumns = []string{
+	"request_method",
+	"request_url",
+	"request_route",
+	"elapsed_time",
+	"cpu_usage",
+	"mem_usage",
+	"err",
+}
+
+// runResult is a request executed by run
+type runResult struct {
+	method    string
+	url       string
+	route     string
+	elapsedMs int64
+	err       string
+}
+
+// runResultToRow converts the message of the runner to its csv row
+func runResultToRow(msg string) []string {
+	fields := make(map[string]string)
+	for _, v := range strings.Split(msg, ";;") {
+		arr := strings.SplitN(v, ":", 2)
+		if len(arr) == 2 {
+			fields[arr[0]] = arr[1]
+		}
+	}
+
+	row := []string{}
+	for _, column := range runResultColumns {
+		row = append(row, fields[column])
+	}
+
+	return row
+}
+
+// readRunResults reads the run results csv of all the inputs one by one, the
+// columns are found by the header so older results still work
+func readRunResults(paths []string, handler func(r runResult) error) error {
+	for _, path := range paths {
+		if err := readRunResultsFile(path, handler); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func readRunResultsFile(path string, handler func(r runResult) error) error {
+	f, err := openInput(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = -1
+
+	columns := make(map[string]int)
+	for i, column := range runResultColumns {
+		columns[column] = i
+	}
+
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		// the header may come again when results are appended to each other
+		if len(row) > 0 && row[0] == runResultColumns[0] {
+			for i, column := range row {
+				columns[column] = i
+			}
+			continue
+		}
+
+		value := func(column string) string {
+			i, ok := columns[column]
+			if !ok || i >= len(row) {
+				return ""
+			}
+
+			return row[i]
+		}
+
+		result := runResult{
+			method: value("request_method"),
+			url:    value("request_url"),
+			route:  value("request_route"),
+			err:    value("err"),
+		}
+
+		if elapsed, err := strconv.ParseInt(value("elapsed_time"), 10, 64); err == nil {
+			result.elapsedMs = elapsed
+		}
+
+		if err := handler(result); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// routeIdPlaceholder replaces the segments that identify a resource
+const routeIdPlaceholder = "{id}"
+
+// routeCardinalityLimit is the default number of distinct segments under the
+// same path from which they are considered ids (ie: usernames, slugs)
+const routeCardinalityLimit = 50
+
+// routeRareHits is the number of requests under which a segment is rare, ids
+// are mostly rare while the static segments are requested again and again
+const routeRareHits = 1
+
+var (
+	routeNumericRegex = regexp.MustCompile(`^\d+$`)
+	routeUuidRegex    = regexp.MustCompile(
+		`^(?i)[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`,
+	)
+	// hex ids have at least a digit, so words made of a-f aren't taken as one
+	routeHexRegex = regexp.MustCompile(`^(?i)[0-9a-f]*[0-9][0-9a-f]*$`)
+)
+
+// routePattern is a route provided by the user, ie: /users/{id}/posts/{post}
+type routePattern struct {
+	raw      string
+	segments []string
+	// partials has the regex of the segments with a parameter and some text,
+	// ie: {id}.json, nil for the other segments
+	partials []*regexp.Regexp
+}
+
+// newRoutePattern splits the route in segments, a segment that is a single
+// parameter matches any segment, one mixing parameters and text is a regex
+func newRoutePattern(path string) routePattern {
+	r := routePattern{raw: path, segments: strings.Split(path, "/")}
+	r.partials = make([]*regexp.Regexp, len(r.segments))
+
+	for i, s := range r.segments {
+		if isRouteParam(s) || !openapiPathParamRegex.MatchString(s) {
+			continue
+		}
+
+		parts := openapiPathParamRegex.Split(s, -1)
+		for j, p := range parts {
+			parts[j] = regexp.QuoteMeta(p)
+		}
+		r.partials[i] = regexp.MustCompile("^" + strings.Join(parts, "[^/]+") + "$")
+	}
+
+	return r
+}
+
+// isRouteParam checks if the whole segment is a parameter, ie: {id}
+func isRouteParam(segment string) bool {
+	return strings.Index(segment, "{") == 0 && strings.LastIndex(segment, "}") == len(segment)-1
+}
+
+// parseRoutePatterns prepares the routes provided by the user
+func parseRoutePatterns(patterns []string) []routePattern {
+	routes := []routePattern{}
+	for _, p := range patterns {
+		p = strings.TrimSpace(p)
+		if len(p) == 0 {
+			continue
+		}
+
+		_, path := splitUrlPath(p)
+		routes = append(routes, newRoutePattern(path))
+	}
+
+	return routes
+}
+
+// matches checks if all the segments match, "{...}" matches any segment and
+// a parameter within a segment any text at its place (ie: {id}.json)
+func (r routePattern) matches(segments []string) bool {
+	if len(r.segments) != len(segments) {
+		return false
+	}
+
+	for i, s := range r.segments {
+		if r.partials[i] != nil {
+			if !r.partials[i].MatchString(segments[i]) {
+				return false
+			}
+			continue
+		}
+
+		if !isRouteParam(s) && s != segments[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// splitUrlPath separates the scheme and host of absolute urls from the path,
+// the query is left out
+func splitUrlPath(requestUrl string) (string, string) {
+	if i := strings.Index(requestUrl, "?"); i >= 0 {
+		requestUrl = requestUrl[:i]
+	}
+
+	if strings.Index(requestUrl, "http://") != 0 && strings.Index(requestUrl, "https://") != 0 {
+		return "", requestUrl
+	}
+
+	// the path starts on the first "/" after the host
+	hostStart := strings.Index(requestUrl, "://") + 3
+	i := strings.Index(requestUrl[hostStart:], "/")
+	if i < 0 {
+		return requestUrl, "/"
+	}
+
+	return requestUrl[:hostStart+i], requestUrl[hostStart+i:]
+}
+
+// isIdSegment checks if the segment is a number, an uuid or an hex id
+func isIdSegment(segment string) bool {
+	if routeNumericRegex.MatchString(segment) || routeUuidRegex.MatchString(segment) {
+		return true
+	}
+
+	return len(segment) >= 8 && routeHexRegex.MatchString(segment)
+}
+
+// routeTemplate returns the route of the url, the first of the patterns that
+// matches is used and if none does the id segments are replaced
+func routeTemplate(requestUrl string, patterns []routePattern) string {
+	prefix, path := splitUrlPath(requestUrl)
+	segments := strings.Split(path, "/")
+
+	for _, p := range patterns {
+		if p.matches(segments) {
+			return prefix + p.raw
+		}
+	}
+
+	for i, s := range segments {
+		if isIdSegment(s) {
+			segments[i] = routeIdPlaceholder
+		}
+	}
+
+	return prefix + strings.Join(segments, "/")
+}
+
+// routeNode is a segment of the routes, used to find the ones with too many
+// distinct children
+type routeNode struct {
+	children map[string]*routeNode
+	// hits is the number of requests under the segment
+	hits int
+}
+
+func newRouteNode() *routeNode {
+	return &routeNode{children: make(map[string]*routeNode)}
+}
+
+func (n *routeNode) insert(segments []string, hits int) {
+	n.hits += hits
+	if len(segments) == 0 {
+		return
+	}
+
+	child, ok := n.children[segments[0]]
+	if !ok {
+		child = newRouteNode()
+		n.children[segments[0]] = child
+	}
+
+	child.insert(segments[1:], hits)
+}
+
+func (n *routeNode) merge(other *routeNode) {
+	n.hits += other.hits
+	for k, c := range other.children {
+		if existing, ok := n.children[k]; ok {
+			existing.merge(c)
+			continue
+		}
+
+		n.children[k] = c
+	}
+}
+
+// collapse replaces the children by the placeholder when there are more than
+// the limit and most of them are rare, a limit of 0 never collapses
+func (n *routeNode) collapse(limit int) {
+	if limit <= 0 {
+		return
+	}
+
+	literals, rare := 0, 0
+	for k, c := range n.children {
+		if k != routeIdPlaceholder {
+			literals += 1
+			if c.hits <= routeRareHits {
+				rare += 1
+			}
+		}
+	}
+
+	if literals > limit && rare*2 > literals {
+		merged, ok := n.children[routeIdPlaceholder]
+		if !ok {
+			merged = newRouteNode()
+		}
+
+		for k, c := range n.children {
+			if k != routeIdPlaceholder {
+				merged.merge(c)
+				delete(n.children, k)
+			}
+		}
+
+		n.children[routeIdPlaceholder] = merged
+	}
+
+	for _, c := range n.children {
+		c.collapse(limit)
+	}
+}
+
+// resolve returns the segments after the collapse
+func (n *routeNode) resolve(segments []string) []string {
+	resolved := []string{}
+
+	node := n
+	for _, s := range segments {
+		next, ok := node.children[s]
+		if !ok {
+			s = routeIdPlaceholder
+			next = node.children[s]
+		}
+
+		resolved = append(resolved, s)
+		if next == nil {
+			return append(resolved, segments[len(resolved):]...)
+		}
+		node = next
+	}
+
+	return resolved
+}
+
+// collapseRoutes finds the segments with too many distinct values under the
+// same path, mostly rare ones, and replaces them by the placeholder, the
+// routes come with their number of requests, returns the new route of each of
+// the routes, the routes matching a pattern are kept as they are
+func collapseRoutes(routes map[string]int, patterns []routePattern, limit int) map[string]string {
+	collapsed := make(map[string]string)
+	roots := make(map[string]*routeNode)
+
+	isPattern := func(route string) bool {
+		_, path := splitUrlPath(route)
+		for _, p := range patterns {
+			if p.raw == path {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	for route, hits := range routes {
+		if isPattern(route) {
+			continue
+		}
+
+		prefix, path := splitUrlPath(route)
+		root, ok := roots[prefix]
+		if !ok {
+			root = newRouteNode()
+			roots[prefix] = root
+		}
+
+		root.insert(strings.Split(path, "/"), hits)
+	}
+
+	for _, root := range roots {
+		root.collapse(limit)
+	}
+
+	for route := range routes {
+		collapsed[route] = route
+		if isPattern(route) {
+			continue
+		}
+
+		prefix, path := splitUrlPath(route)
+		collapsed[route] = prefix + strings.Join(roots[prefix].resolve(strings.Split(path, "/")), "/")
+	}
+
+	return collapsed
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestCollapseRoutesKeepsWideStaticTree(t *testing.T) {
+	// 60 resources requested again and again aren't ids
+	static := make(map[string]int)
+	for i := 0; i < 60; i++ {
+		static[fmt.Sprintf("/api/resource%c%c/list", 'a'+i/26, 'a'+i%26)] = 3
+	}
+
+	collapsed := collapseRoutes(static, nil, routeCardinalityLimit)
+	for route := range static {
+		if collapsed[route] != route {
+			t.Errorf("%s collapsed to %s", route, collapsed[route])
+		}
+	}
+
+	// 60 usernames seen once each are
+	users := make(map[string]int)
+	for i := 0; i < 60; i++ {
+		users[fmt.Sprintf("/users/user%c%c/posts", 'a'+i/26, 'a'+i%26)] = 1
+	}
+
+	collapsed = collapseRoutes(users, nil, routeCardinalityLimit)
+	for route := range users {
+		if collapsed[route] != "/users/{id}/posts" {
+			t.Errorf("%s collapsed to %s, want /users/{id}/posts", route, collapsed[route])
+		}
+	}
+
+	// a limit of 0 keeps them
+	collapsed = collapseRoutes(users, nil, 0)
+	for route := range users {
+		if collapsed[route] != route {
+			t.Errorf("%s collapsed to %s with no limit", route, collapsed[route])
+		}
+	}
+}
@@ -1,18 +1,18 @@
 package main
 
 import (
-	"bufio"
 	"bytes"
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
-	"reflect"
+	"os/signal"
 	"regexp"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/shirou/gopsutil/cpu"
@@ -21,17 +21,22 @@ import (
 
 type queueJob struct {
 	data source
+	// done is called once the request was executed
+	done func(err error)
 }
 
 type queue struct {
 	runningCount int
-	list         []source
+	list         []queueJob
 	informer     io.Writer
 
 	baseUrl     string
+	routes      []routePattern
 	timerMs     int
 	concurrency int
 	mu          sync.Mutex
+	informerMu  sync.Mutex
+	wg          sync.WaitGroup
 }
 
 func getMemPercent() float64 {
@@ -62,7 +67,7 @@ func newQueue(baseUrl string, timerMs int, concurrency int, informer io.Writer)
 
 	return &queue{
 		runningCount: 0,
-		list:         []source{},
+		list:         []queueJob{},
 		informer:     informer,
 
 		baseUrl:     parsedBaseUrl,
@@ -77,16 +82,16 @@ func (q *queue) doRequest(job source) error {
 
 	method := strings.ToUpper(job.RequestMethod)
 
+	// prepare the body on its encoding
+	body, contentType, err := sourceBody(job)
+	if err != nil {
+		return err
+	}
+
 	// set the request
-	if job.RequestBody == nil {
+	if body == nil {
 		req, err = http.NewRequest(method, job.RequestUrl, nil)
 	} else {
-		// prepare the body
-		body, err := json.Marshal(job.RequestBody)
-		if err != nil {
-			return err
-		}
-
 		req, err = http.NewRequest(method, job.RequestUrl, bytes.NewReader(body))
 	}
 
@@ -94,13 +99,16 @@ func (q *queue) doRequest(job source) error {
 		return err
 	}
 
-	// set the headers
+	// set the headers, repeated headers are added one by one
 	for k, v := range job.RequestHeaders {
-		if v == nil || reflect.TypeOf(v).String() != "string" {
-			continue
+		for _, value := range headerValues(v) {
+			req.Header.Add(k, value)
 		}
+	}
 
-		req.Header.Set(k, v.(string))
+	// the body may need a content type other than the recorded (ie: boundaries)
+	if body != nil && len(contentType) > 0 {
+		req.Header.Set("Content-Type", contentType)
 	}
 
 	_, err = http.DefaultClient.Do(req)
@@ -147,38 +155,59 @@ func (q *queue) jobHandler(job source) (time.Duration, float64, float64, error)
 }
 
 func (q *queue) nextJob() {
+	q.mu.Lock()
+
 	// we dont have any more space to keep running
-	if q.concurrency >= q.runningCount || len(q.list) == 0 {
+	if q.runningCount >= q.concurrency || len(q.list) == 0 {
+		q.mu.Unlock()
 		return
 	}
 
 	// pop out the first one in queue
 	next := q.list[0]
 	q.list = q.list[1:]
+	q.runningCount += 1
+	q.mu.Unlock()
 
-	go func(job source) {
+	go func(queued queueJob) {
+		job := queued.data
 		elapsed, cpuUsed, memUsed, err := q.jobHandler(job)
 
 		if q.informer != nil {
 			// setup a message to inform
 			msg := fmt.Sprintf(
-				"request_method:%s;;request_url:%s",
+				"request_method:%s;;request_url:%s;;request_route:%s",
 				job.RequestMethod,
 				job.RequestUrl,
+				routeTemplate(job.RequestUrl, q.routes),
 			)
 			if err != nil {
 				msg = fmt.Sprintf("%s;;err:%s", msg, err.Error())
 			} else {
-				msg = fmt.Sprintf("%s;;elapsed_time:%d;;cpu_usage:%.2f;;mem_usage:%.2f", elapsed, cpuUsed, memUsed)
+				msg = fmt.Sprintf(
+					"%s;;elapsed_time:%d;;cpu_usage:%.2f;;mem_usage:%.2f",
+					msg,
+					elapsed.Milliseconds(),
+					cpuUsed,
+					memUsed,
+				)
 			}
 
+			// the informer may not handle concurrent writes
+			q.informerMu.Lock()
 			_, _ = q.informer.Write([]byte(msg))
+			q.informerMu.Unlock()
+		}
+
+		if queued.done != nil {
+			queued.done(err)
 		}
 
 		// make sure we remove the job from the running list
 		q.mu.Lock()
 		q.runningCount -= 1
 		q.mu.Unlock()
+		q.wg.Done()
 
 		// before setting out the next request, wait
 		if q.timerMs > 0 {
@@ -189,21 +218,33 @@ func (q *queue) nextJob() {
 	}(next)
 }
 
-func (q *queue) addToQueue(job source) {
-	// construct protocol
-	url := job.RequestUrl
-	if strings.Contains(url, "https://") || strings.Contains(url, "http://") {
-		job.RequestUrl = q.baseUrl + url
-	}
+// addToQueue queues the request, done is optional and called once the
+// request was executed
+func (q *queue) addToQueue(job source, done func(err error)) {
+	// construct protocol, the query goes back to the url
+	job.RequestUrl = sourceUrl(q.baseUrl, sourceRequestUrl(job))
+	job.RequestQuery = nil
+
+	q.wg.Add(1)
+	q.mu.Lock()
+	q.list = append(q.list, queueJob{data: job, done: done})
+	q.mu.Unlock()
 
-	q.list = append(q.list, job)
 	q.nextJob()
 }
 
 func (q *queue) getJobCount() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
 	return len(q.list) + q.runningCount
 }
 
+// wait blocks until all the queued requests were executed
+func (q *queue) wait() {
+	q.wg.Wait()
+}
+
 func isSourceFiltered(job source, ignorePatterns []string) bool {
 	for _, p := range ignorePatterns {
 		p = strings.ReplaceAll(p, " ", "")
@@ -239,10 +280,18 @@ func isSourceFiltered(job source, ignorePatterns []string) bool {
 			continue
 		}
 
-		i := r.FindIndex([]byte(job.RequestUrl))
-		if len(i) > 0 {
+		// the query is part of the url, each parameter is also matched on its
+		// own as name=value so the patterns can target them
+		if r.MatchString(sourceRequestUrl(job)) {
 			return true
 		}
+		for name, values := range sourceQueryValues(job) {
+			for _, v := range values {
+				if r.MatchString(name + "=" + v) {
+					return true
+				}
+			}
+		}
 	}
 
 	return false
@@ -254,51 +303,50 @@ func run(
 	concurrency int,
 	timerMs int,
 	ignorePatterns []string,
+	routePatterns []string,
+	group string,
 	informer io.Writer,
 ) error {
 	if len(inputPath) == 0 {
 		return errors.New("input path is required")
 	}
 
-	file, err := os.Open(inputPath)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
 	q := newQueue(baseUrl, timerMs, concurrency, informer)
+	q.routes = parseRoutePatterns(routePatterns)
 
-	// run a scanner line by line on the file
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		v := strings.ToLower(scanner.Text())
-		if len(v) == 0 || strings.Index(v, "#") == 0 {
-			continue
-		}
+	// redis streams and channels keep running until interrupted
+	if isRedisSource(removeSpaces(inputPath)) {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
 
-		sources, err := rawToSource(v)
-		if err != nil {
-			return err
-		}
+		return runRedisTail(ctx, inputPath, q, group, ignorePatterns)
+	}
 
-		for _, s := range sources {
-			if isSourceFiltered(s, ignorePatterns) {
-				continue
-			}
+	paths, err := inputPaths(inputPath)
+	if err != nil {
+		return err
+	}
 
-			q.addToQueue(s)
+	err = readRecords(paths, func(s source) error {
+		if isSourceFiltered(s, ignorePatterns) {
+			return nil
 		}
 
+		q.addToQueue(s, nil)
+
 		// we might be loading a lot into memory, lets slow down a bit
 		// so that we can remove some of the old sources
 		if q.getJobCount() > 5000 {
 			time.Sleep(time.Second * 2)
 		}
-	}
 
-	if err := scanner.Err(); err != nil {
+		return nil
+	})
+	if err != nil {
 		return err
 	}
 
+	q.wait()
+
 	return nil
 }
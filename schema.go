@@ -0,0 +1,503 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"mime"
+	"sort"
+	"strings"
+
+	"request_analyser/capture"
+)
+
+// schemaEnumLimit is the number of distinct values under which the strings
+// of a field are taken as an enum
+const schemaEnumLimit = 10
+
+// schemaOutlierShare is the share of the bodies under which a type or a field
+// is unusual, and over which (once removed from 1) a field is expected
+const schemaOutlierShare = 0.1
+
+// schemaOutlierMinBodies is the number of bodies needed to tell the majority
+// shape apart from the outliers
+const schemaOutlierMinBodies = 10
+
+// schemaShapesLimit caps the distinct shapes kept per request
+const schemaShapesLimit = 1000
+
+// schemaOutliersLimit is the number of outlier shapes reported per request
+const schemaOutliersLimit = 10
+
+// schemaNode is the shape inferred from all the values seen at a place of the
+// bodies
+type schemaNode struct {
+	count int
+	types map[string]int
+	// objects is the number of objects, properties count how many had each
+	objects    int
+	properties map[string]*schemaNode
+	items      *schemaNode
+	// strings counts the distinct strings, up to the enum limit
+	strings         map[string]int
+	stringsOverflow bool
+}
+
+func newSchemaNode() *schemaNode {
+	return &schemaNode{
+		types:      make(map[string]int),
+		properties: make(map[string]*schemaNode),
+		strings:    make(map[string]int),
+	}
+}
+
+// jsonType returns the json schema type of a decoded json value
+func jsonType(v interface{}) string {
+	switch value := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if value == math.Trunc(value) {
+			return "integer"
+		}
+		return "number"
+	case json.Number:
+		if _, err := value.Int64(); err == nil {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	}
+
+	return "string"
+}
+
+// add merges a value into the shape
+func (n *schemaNode) add(v interface{}) {
+	n.count += 1
+	n.types[jsonType(v)] += 1
+
+	switch value := v.(type) {
+	case string:
+		if _, ok := n.strings[value]; !ok && len(n.strings) >= schemaEnumLimit {
+			n.stringsOverflow = true
+			break
+		}
+		n.strings[value] += 1
+		break
+	case map[string]interface{}:
+		n.objects += 1
+		for k, item := range value {
+			prop, ok := n.properties[k]
+			if !ok {
+				prop = newSchemaNode()
+				n.properties[k] = prop
+			}
+			prop.add(item)
+		}
+		break
+	case []interface{}:
+		for _, item := range value {
+			if n.items == nil {
+				n.items = newSchemaNode()
+			}
+			n.items.add(item)
+		}
+		break
+	}
+}
+
+// merge adds the shape inferred from other values
+func (n *schemaNode) merge(other *schemaNode) {
+	n.count += other.count
+	n.objects += other.objects
+	n.stringsOverflow = n.stringsOverflow || other.stringsOverflow
+
+	for t, c := range other.types {
+		n.types[t] += c
+	}
+
+	for v, c := range other.strings {
+		if _, ok := n.strings[v]; !ok && len(n.strings) >= schemaEnumLimit {
+			n.stringsOverflow = true
+			continue
+		}
+		n.strings[v] += c
+	}
+
+	for k, p := range other.properties {
+		prop, ok := n.properties[k]
+		if !ok {
+			// merged into a new node so the other shape isn't shared
+			prop = newSchemaNode()
+			n.properties[k] = prop
+		}
+		prop.merge(p)
+	}
+
+	if other.items != nil {
+		if n.items == nil {
+			n.items = newSchemaNode()
+		}
+		n.items.merge(other.items)
+	}
+}
+
+// typeShare returns the share of the values with the type, integers and
+// numbers are the same for it
+func (n *schemaNode) typeShare(t string) float64 {
+	count := n.types[t]
+	if t == "integer" || t == "number" {
+		count = n.types["integer"] + n.types["number"]
+	}
+
+	return float64(count) / float64(n.count)
+}
+
+// majorType returns the most seen type
+func (n *schemaNode) majorType() string {
+	major := ""
+	for t, c := range n.types {
+		if len(major) == 0 || c > n.types[major] || (c == n.types[major] && t < major) {
+			major = t
+		}
+	}
+
+	return major
+}
+
+// schema returns the inferred json schema
+func (n *schemaNode) schema() map[string]interface{} {
+	schema := make(map[string]interface{})
+
+	types := n.sortedTypes()
+	if len(types) == 1 {
+		schema["type"] = types[0]
+	} else if len(types) > 1 {
+		schema["type"] = types
+	}
+
+	if n.objects > 0 {
+		properties := make(map[string]interface{})
+		for k, p := range n.properties {
+			properties[k] = p.schema()
+		}
+
+		schema["properties"] = properties
+		if required := n.required(); len(required) > 0 {
+			schema["required"] = required
+		}
+	}
+
+	if n.items != nil {
+		schema["items"] = n.items.schema()
+	}
+
+	if enum := n.enum(); enum != nil {
+		schema["enum"] = enum
+	}
+
+	return schema
+}
+
+// sortedTypes returns the types seen, sorted
+func (n *schemaNode) sortedTypes() []string {
+	types := []string{}
+	for t := range n.types {
+		// integers are numbers when both are seen
+		if t == "integer" && n.types["number"] > 0 {
+			continue
+		}
+		types = append(types, t)
+	}
+	sort.Strings(types)
+
+	return types
+}
+
+// required returns the properties on every object, sorted
+func (n *schemaNode) required() []string {
+	required := []string{}
+	for k, p := range n.properties {
+		if p.count == n.objects {
+			required = append(required, k)
+		}
+	}
+	sort.Strings(required)
+
+	return required
+}
+
+// enum returns the strings seen as an enum, nil when they aren't one
+func (n *schemaNode) enum() []string {
+	return stringEnum(n.strings, n.types["string"], n.stringsOverflow)
+}
+
+// stringEnum returns the distinct strings sorted when they are an enum, nil
+// otherwise, strings repeating over a few values are an enum
+func stringEnum(values map[string]int, count int, overflow bool) []string {
+	if count == 0 || overflow || len(values) > schemaEnumLimit || count <= len(values) {
+		return nil
+	}
+
+	enum := []string{}
+	for v := range values {
+		enum = append(enum, v)
+	}
+	sort.Strings(enum)
+
+	return enum
+}
+
+// mismatches returns how a value differs from the majority of the values
+func (n *schemaNode) mismatches(v interface{}, path string) []string {
+	t := jsonType(v)
+	if n.typeShare(t) < schemaOutlierShare {
+		return []string{fmt.Sprintf("%s is %s, usually %s", path, t, n.majorType())}
+	}
+
+	reasons := []string{}
+	switch value := v.(type) {
+	case map[string]interface{}:
+		keys := []string{}
+		for k := range n.properties {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			prop := n.properties[k]
+			share := float64(prop.count) / float64(n.objects)
+
+			item, ok := value[k]
+			if !ok {
+				if share >= 1-schemaOutlierShare {
+					reasons = append(reasons, "missing "+path+"."+k)
+				}
+				continue
+			}
+
+			if share < schemaOutlierShare {
+				reasons = append(reasons, "unexpected "+path+"."+k)
+				continue
+			}
+
+			reasons = append(reasons, prop.mismatches(item, path+"."+k)...)
+		}
+		break
+	case []interface{}:
+		// the items share their reasons, each is reported once
+		seen := make(map[string]bool)
+		for _, item := range value {
+			for _, r := range n.items.mismatches(item, path+"[]") {
+				if !seen[r] {
+					seen[r] = true
+					reasons = append(reasons, r)
+				}
+			}
+		}
+		break
+	}
+
+	return reasons
+}
+
+// bodyShapeOf returns the structure of a value, the same for the values with
+// the same fields and types
+func bodyShapeOf(v interface{}) string {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		fields := []string{}
+		for k, item := range value {
+			fields = append(fields, k+":"+bodyShapeOf(item))
+		}
+		sort.Strings(fields)
+		return "{" + strings.Join(fields, ",") + "}"
+	case []interface{}:
+		items := []string{}
+		seen := make(map[string]bool)
+		for _, item := range value {
+			shape := bodyShapeOf(item)
+			if !seen[shape] {
+				seen[shape] = true
+				items = append(items, shape)
+			}
+		}
+		sort.Strings(items)
+		return "[" + strings.Join(items, "|") + "]"
+	}
+
+	return jsonType(v)
+}
+
+// redactExample replaces the values of a body by their type, the structure
+// is kept, ie: {"age":"(integer)","tags":["(string)"]}
+func redactExample(v interface{}) interface{} {
+	switch value := v.(type) {
+	case nil:
+		return nil
+	case map[string]interface{}:
+		redacted := make(map[string]interface{})
+		for k, item := range value {
+			redacted[k] = redactExample(item)
+		}
+		return redacted
+	case []interface{}:
+		redacted := []interface{}{}
+		for _, item := range value {
+			redacted = append(redacted, redactExample(item))
+		}
+		return redacted
+	}
+
+	return "(" + jsonType(v) + ")"
+}
+
+// bodyShape is a distinct structure of the bodies with one of them
+type bodyShape struct {
+	count         int
+	example       interface{}
+	exampleUnixMs int64
+}
+
+// bodyOutlier is a shape of the bodies that doesn't match the majority
+type bodyOutlier struct {
+	shape   *bodyShape
+	reasons []string
+}
+
+// bodyStat has the bodies of a request
+type bodyStat struct {
+	count int
+	// encodings counts the bodies per encoding, only json and form bodies are
+	// inferred
+	encodings map[string]int
+	// mediaTypes counts the content types of the bodies per encoding
+	mediaTypes map[string]map[string]int
+	schema     *schemaNode
+	shapes     map[string]*bodyShape
+	// shapesOverflow is set when there were more shapes than the limit
+	shapesOverflow bool
+}
+
+func newBodyStat() *bodyStat {
+	return &bodyStat{
+		encodings:  make(map[string]int),
+		mediaTypes: make(map[string]map[string]int),
+		schema:     newSchemaNode(),
+		shapes:     make(map[string]*bodyShape),
+	}
+}
+
+// addShape counts the bodies of a shape, the first one is kept as example
+func (b *bodyStat) addShape(key string, shape *bodyShape) {
+	existing, ok := b.shapes[key]
+	if ok {
+		existing.count += shape.count
+		return
+	}
+
+	if len(b.shapes) >= schemaShapesLimit {
+		b.shapesOverflow = true
+		return
+	}
+
+	// a copy, the shapes of a merged stat keep counting on their own
+	kept := *shape
+	b.shapes[key] = &kept
+}
+
+// addMediaType counts the bodies of an encoding sent with the content type
+func (b *bodyStat) addMediaType(encoding string, mediaType string, count int) {
+	if _, ok := b.mediaTypes[encoding]; !ok {
+		b.mediaTypes[encoding] = make(map[string]int)
+	}
+
+	b.mediaTypes[encoding][mediaType] += count
+}
+
+// add counts the body of a request
+func (b *bodyStat) add(s source) {
+	if s.RequestBody == nil {
+		return
+	}
+
+	encoding := s.RequestBodyEncoding
+	if len(encoding) == 0 {
+		encoding = capture.BodyJson
+	}
+
+	b.count += 1
+	b.encodings[encoding] += 1
+
+	mediaType, _, err := mime.ParseMediaType(sourceHeader(s.RequestHeaders, "Content-Type"))
+	if err == nil {
+		b.addMediaType(encoding, mediaType, 1)
+	}
+
+	if encoding != capture.BodyJson && encoding != capture.BodyForm {
+		return
+	}
+
+	b.schema.add(s.RequestBody)
+	b.addShape(bodyShapeOf(s.RequestBody), &bodyShape{
+		count:         1,
+		example:       s.RequestBody,
+		exampleUnixMs: s.UnixMs,
+	})
+}
+
+// merge adds the bodies of the same request
+func (b *bodyStat) merge(other *bodyStat) {
+	b.count += other.count
+	b.shapesOverflow = b.shapesOverflow || other.shapesOverflow
+
+	for e, c := range other.encodings {
+		b.encodings[e] += c
+	}
+
+	for e, mediaTypes := range other.mediaTypes {
+		for mediaType, c := range mediaTypes {
+			b.addMediaType(e, mediaType, c)
+		}
+	}
+
+	b.schema.merge(other.schema)
+
+	for k, shape := range other.shapes {
+		b.addShape(k, shape)
+	}
+}
+
+// outliers returns the shapes that don't match the majority, the most seen
+// first, there are none until there are enough bodies to tell
+func (b *bodyStat) outliers() []bodyOutlier {
+	outliers := []bodyOutlier{}
+	if b.schema.count < schemaOutlierMinBodies {
+		return outliers
+	}
+
+	for _, shape := range b.shapes {
+		reasons := b.schema.mismatches(shape.example, "$")
+		if len(reasons) > 0 {
+			outliers = append(outliers, bodyOutlier{shape: shape, reasons: reasons})
+		}
+	}
+
+	sort.Slice(outliers, func(i, j int) bool {
+		if outliers[i].shape.count == outliers[j].shape.count {
+			return outliers[i].shape.exampleUnixMs < outliers[j].shape.exampleUnixMs
+		}
+
+		return outliers[i].shape.count > outliers[j].shape.count
+	})
+
+	return outliers
+}
@@ -0,0 +1,452 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"request_analyser/capture"
+)
+
+// specIgnoredHeaders are the headers left out of the operations, openapi
+// describes accept, content-type and authorization on their own and the rest
+// are set by the clients and proxies on any request
+var specIgnoredHeaders = map[string]bool{
+	"Accept":            true,
+	"Accept-Encoding":   true,
+	"Accept-Language":   true,
+	"Authorization":     true,
+	"Cache-Control":     true,
+	"Connection":        true,
+	"Content-Length":    true,
+	"Content-Type":      true,
+	"Cookie":            true,
+	"Host":              true,
+	"Origin":            true,
+	"Pragma":            true,
+	"Referer":           true,
+	"Te":                true,
+	"Transfer-Encoding": true,
+	"Upgrade":           true,
+	"User-Agent":        true,
+	"Via":               true,
+	"X-Forwarded-For":   true,
+	"X-Forwarded-Host":  true,
+	"X-Forwarded-Proto": true,
+	"X-Real-Ip":         true,
+}
+
+// specBodyContentTypes is the content type described for each body encoding
+var specBodyContentTypes = map[string]string{
+	capture.BodyJson:      "application/json",
+	capture.BodyForm:      "application/x-www-form-urlencoded",
+	capture.BodyMultipart: "multipart/form-data",
+	capture.BodyText:      "text/plain",
+	capture.BodyBase64:    "application/octet-stream",
+}
+
+// setOperation sets the operation of the method, false when the method has
+// no operation on openapi
+func (p *openapiPathItem) setOperation(method string, op *openapiOperation) bool {
+	switch method {
+	case "GET":
+		p.Get = op
+		break
+	case "PUT":
+		p.Put = op
+		break
+	case "POST":
+		p.Post = op
+		break
+	case "DELETE":
+		p.Delete = op
+		break
+	case "OPTIONS":
+		p.Options = op
+		break
+	case "HEAD":
+		p.Head = op
+		break
+	case "PATCH":
+		p.Patch = op
+		break
+	case "TRACE":
+		p.Trace = op
+		break
+	default:
+		return false
+	}
+
+	return true
+}
+
+// openapiSchema converts the inferred schema to an openapi 3.0 schema, null
+// is nullable and values of several types are any of them
+func (n *schemaNode) openapiSchema() *openapiSchema {
+	types := []string{}
+	nullable := false
+	for _, t := range n.sortedTypes() {
+		if t == "null" {
+			nullable = true
+			continue
+		}
+		types = append(types, t)
+	}
+
+	if len(types) > 1 {
+		schema := &openapiSchema{Nullable: nullable}
+		for _, t := range types {
+			only := *n
+			only.types = map[string]int{t: n.types[t]}
+			schema.AnyOf = append(schema.AnyOf, only.openapiSchema())
+		}
+		return schema
+	}
+
+	schema := &openapiSchema{Nullable: nullable}
+	if len(types) == 0 {
+		return schema
+	}
+	schema.Type = openapiSchemaType(types[0])
+
+	switch types[0] {
+	case "object":
+		schema.Properties = make(map[string]*openapiSchema)
+		for k, p := range n.properties {
+			schema.Properties[k] = p.openapiSchema()
+		}
+		if required := n.required(); len(required) > 0 {
+			schema.Required = required
+		}
+		break
+	case "array":
+		schema.Items = &openapiSchema{}
+		if n.items != nil {
+			schema.Items = n.items.openapiSchema()
+		}
+		break
+	case "string":
+		for _, v := range n.enum() {
+			schema.Enum = append(schema.Enum, v)
+		}
+		break
+	}
+
+	return schema
+}
+
+// paramSchema infers the schema of a query parameter from its values
+func paramSchema(p *paramStat) *openapiSchema {
+	isType := func(check func(v string) bool) bool {
+		for v := range p.values {
+			if !check(v) {
+				return false
+			}
+		}
+		return len(p.values) > 0
+	}
+
+	schema := &openapiSchema{Type: "string"}
+	switch {
+	case isType(func(v string) bool { _, err := strconv.ParseInt(v, 10, 64); return err == nil }):
+		schema.Type = "integer"
+		break
+	case isType(func(v string) bool { _, err := strconv.ParseFloat(v, 64); return err == nil }):
+		schema.Type = "number"
+		break
+	case isType(func(v string) bool { return v == "true" || v == "false" }):
+		schema.Type = "boolean"
+		break
+	default:
+		for _, v := range stringEnum(p.values, p.count, p.overflow) {
+			schema.Enum = append(schema.Enum, v)
+		}
+	}
+
+	if p.repeated {
+		return &openapiSchema{Type: "array", Items: schema}
+	}
+
+	return schema
+}
+
+// specPath converts the route to an openapi path, the parameters of the route
+// are named after it and numbered when repeated (ie: /users/{id}/posts/{id2})
+func specPath(path string) (string, []string) {
+	segments := strings.Split(path, "/")
+	names := []string{}
+	seen := make(map[string]int)
+
+	for i, s := range segments {
+		if strings.Index(s, "{") != 0 || strings.LastIndex(s, "}") != len(s)-1 {
+			continue
+		}
+
+		name := strings.Trim(s, "{}")
+		if len(name) == 0 {
+			name = "id"
+		}
+
+		seen[name] += 1
+		if seen[name] > 1 {
+			name = fmt.Sprintf("%s%d", name, seen[name])
+		}
+
+		segments[i] = "{" + name + "}"
+		names = append(names, name)
+	}
+
+	if path := strings.Join(segments, "/"); len(path) > 0 {
+		return path, names
+	}
+
+	return "/", names
+}
+
+// specOperationId names the operation after its method and path, ie:
+// GET /users/{id} -> getUsersId
+func specOperationId(method string, path string) string {
+	id := strings.ToLower(method)
+	for _, s := range strings.FieldsFunc(path, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9')
+	}) {
+		id += strings.ToUpper(s[:1]) + s[1:]
+	}
+
+	return id
+}
+
+// specSecurityScheme returns the name and security scheme of an
+// authorization scheme
+func specSecurityScheme(scheme string) (string, *openapiSecurityScheme) {
+	switch scheme {
+	case "bearer":
+		return "bearerAuth", &openapiSecurityScheme{Type: "http", Scheme: "bearer"}
+	case "basic":
+		return "basicAuth", &openapiSecurityScheme{Type: "http", Scheme: "basic"}
+	}
+
+	return "authorizationHeader", &openapiSecurityScheme{
+		Type: "apiKey",
+		In:   "header",
+		Name: "Authorization",
+	}
+}
+
+// specOperation describes a request of the stats as an operation
+func specOperation(doc *openapiDocument, r reqStat, pathParams []string) *openapiOperation {
+	op := &openapiOperation{
+		Summary:    fmt.Sprintf("Seen on %d requests", r.count),
+		Parameters: []*openapiParameter{},
+		Responses: map[string]*openapiResponse{
+			"default": {Description: "Responses aren't recorded"},
+		},
+	}
+
+	for _, name := range pathParams {
+		op.Parameters = append(op.Parameters, &openapiParameter{
+			Name:     name,
+			In:       "path",
+			Required: true,
+			Schema:   &openapiSchema{Type: "string"},
+		})
+	}
+
+	for _, p := range sortedParams(r.params) {
+		op.Parameters = append(op.Parameters, &openapiParameter{
+			Name:     p.name,
+			In:       "query",
+			Required: p.count == r.count,
+			Schema:   paramSchema(p),
+		})
+	}
+
+	headers := []string{}
+	for name := range r.headers {
+		if !specIgnoredHeaders[name] && strings.Index(name, "Sec-") != 0 {
+			headers = append(headers, name)
+		}
+	}
+	sort.Strings(headers)
+
+	for _, name := range headers {
+		op.Parameters = append(op.Parameters, &openapiParameter{
+			Name:     name,
+			In:       "header",
+			Required: r.headers[name] == r.count,
+			Schema:   &openapiSchema{Type: "string"},
+		})
+	}
+
+	// any of the authorization schemes seen is accepted
+	for _, scheme := range sortedByCount(r.authSchemes) {
+		name, securityScheme := specSecurityScheme(scheme)
+		doc.Components.SecuritySchemes[name] = securityScheme
+		op.Security = append(op.Security, map[string][]string{name: {}})
+	}
+
+	if r.bodies != nil && r.bodies.count > 0 {
+		op.RequestBody = &openapiRequestBody{
+			Required: r.bodies.count == r.count,
+			Content:  make(map[string]*openapiMediaType),
+		}
+
+		for encoding := range r.bodies.encodings {
+			var schema *openapiSchema
+			switch encoding {
+			case capture.BodyJson, capture.BodyForm:
+				schema = r.bodies.schema.openapiSchema()
+				break
+			case capture.BodyMultipart:
+				schema = &openapiSchema{Type: "object"}
+				break
+			case capture.BodyBase64:
+				schema = &openapiSchema{Type: "string", Format: "binary"}
+				break
+			default:
+				schema = &openapiSchema{Type: "string"}
+			}
+
+			// the content types recorded describe the bodies, ie: xml for a text body
+			contentTypes := sortedByCount(r.bodies.mediaTypes[encoding])
+			if len(contentTypes) == 0 {
+				contentType, ok := specBodyContentTypes[encoding]
+				if !ok {
+					contentType = "application/octet-stream"
+				}
+				contentTypes = []string{contentType}
+			}
+
+			for _, contentType := range contentTypes {
+				op.RequestBody.Content[contentType] = &openapiMediaType{Schema: schema}
+			}
+		}
+	}
+
+	return op
+}
+
+// recordsToSpec describes the requests of the records as an openapi 3
+// document, grouped by the same routes as the stats
+func recordsToSpec(inputPath string, title string, routePatterns []string) (*openapiDocument, error) {
+	// the headers of each request give its parameters and security
+	data, err := stats(inputPath, 0, routePatterns, routeCardinalityLimit, false, 0, true, false, true, defaultRedactedHeaders)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := &openapiDocument{
+		Openapi: "3.0.3",
+		Info:    openapiInfo{Title: title, Version: "1.0.0"},
+		Paths:   make(map[string]*openapiPathItem),
+		Components: &openapiComponents{
+			SecuritySchemes: make(map[string]*openapiSecurityScheme),
+		},
+	}
+
+	// the hosts of absolute urls are the servers, the same path on several
+	// hosts is the same operation
+	servers := make(map[string]bool)
+	merged := make(map[string]*reqStat)
+	keys := []string{}
+	for i := range data.requests {
+		r := &data.requests[i]
+
+		prefix, path := splitUrlPath(r.url)
+		if len(prefix) > 0 {
+			servers[prefix] = true
+		}
+
+		key := r.method + " " + path
+		if existing, ok := merged[key]; ok {
+			existing.merge(r)
+			continue
+		}
+
+		r.url = path
+		merged[key] = r
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	operationIds := make(map[string]int)
+	for _, key := range keys {
+		r := merged[key]
+		path, pathParams := specPath(r.url)
+
+		// the path is only documented once it has an operation
+		item, ok := doc.Paths[path]
+		if !ok {
+			item = &openapiPathItem{}
+		}
+
+		op := specOperation(doc, *r, pathParams)
+		if !item.setOperation(r.method, op) {
+			continue
+		}
+		doc.Paths[path] = item
+
+		// the ids have to be unique, the paths may differ on symbols only
+		op.OperationId = specOperationId(r.method, path)
+		operationIds[op.OperationId] += 1
+		if operationIds[op.OperationId] > 1 {
+			op.OperationId += fmt.Sprintf("%d", operationIds[op.OperationId])
+		}
+	}
+
+	for s := range servers {
+		doc.Servers = append(doc.Servers, openapiServer{Url: s})
+	}
+	sort.Slice(doc.Servers, func(i, j int) bool { return doc.Servers[i].Url < doc.Servers[j].Url })
+
+	if len(doc.Components.SecuritySchemes) == 0 {
+		doc.Components = nil
+	}
+
+	return doc, nil
+}
+
+// spec writes the openapi document of the records as json or yaml, an empty
+// output path writes to the stdout
+func spec(
+	inputPath string,
+	outputPath string,
+	format string,
+	title string,
+	routePatterns []string,
+) error {
+	doc, err := recordsToSpec(inputPath, title, routePatterns)
+	if err != nil {
+		return err
+	}
+
+	var raw []byte
+	switch strings.ToLower(format) {
+	case "yaml", "yml":
+		raw, err = yaml.Marshal(doc)
+		break
+	case "json":
+		raw, err = json.MarshalIndent(doc, "", "  ")
+		raw = append(raw, '\n')
+		break
+	default:
+		return errors.New("spec format not supported: " + format)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if len(outputPath) == 0 {
+		_, err := os.Stdout.Write(raw)
+		return err
+	}
+
+	return os.WriteFile(outputPath, raw, 0644)
+}
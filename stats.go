@@ -1,9 +1,9 @@
 package main
 
 import (
-	"bufio"
 	"errors"
-	"os"
+	"log"
+	"net/textproto"
 	"sort"
 	"strings"
 )
@@ -12,102 +12,313 @@ type reqStat struct {
 	count  int
 	method string
 	url    string
+	// params has the usage of each query parameter
+	params map[string]*paramStat
+	// headers counts the requests with each header, by its canonical name,
+	// the headers of the requests are only counted when asked for
+	headers map[string]int
+	// authSchemes counts the requests per scheme of the authorization header
+	authSchemes map[string]int
+	// clients counts the requests per family of the user agent
+	clients map[string]int
+	// the run results have the time of the requests, timed is the number of
+	// requests with one as errors have none
+	timed     int
+	elapsedMs int64
+	errors    int
+	// timeline has the requests per bucket of time, nil when not asked for
+	timeline *timeline
+	// bodies has the shapes of the request bodies, nil when not asked for
+	bodies *bodyStat
 }
-type reqStatArr []reqStat
 
-func (r reqStatArr) Len() int           { return len(r) }
-func (r reqStatArr) Less(i, j int) bool { return r[i].count < r[j].count }
-func (r reqStatArr) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+// merge adds the stats of the same request
+func (r *reqStat) merge(other *reqStat) {
+	r.count += other.count
+	r.timed += other.timed
+	r.elapsedMs += other.elapsedMs
+	r.errors += other.errors
+
+	if r.timeline != nil && other.timeline != nil {
+		r.timeline.merge(other.timeline)
+	}
+
+	if r.bodies != nil && other.bodies != nil {
+		r.bodies.merge(other.bodies)
+	}
+
+	for name, c := range other.headers {
+		r.headers[name] += c
+	}
+
+	for scheme, c := range other.authSchemes {
+		r.authSchemes[scheme] += c
+	}
+
+	for client, c := range other.clients {
+		r.clients[client] += c
+	}
+
+	for name, p := range other.params {
+		if existing, ok := r.params[name]; ok {
+			existing.merge(p)
+			continue
+		}
+
+		r.params[name] = p
+	}
+}
 
 type statsData struct {
-	count              int
-	requests           []reqStat
+	count int
+	// requests are sorted by count, the most used first
+	requests []reqStat
+	// mostUsed are the first of the requests, up to the limit
 	mostUsed           []reqStat
 	requestMethodCount map[string]int
+	// namespaces has the requests rolled up per level of the url
+	namespaces *namespaceNode
+	// timeline has all the requests per bucket of time, nil when not asked for
+	timeline *timeline
+	// inferBodies infers the schema of the bodies of each request
+	inferBodies bool
+	// bodyExamples keeps the values of the outlier bodies, they are redacted
+	// otherwise
+	bodyExamples bool
+	// headers has the usage of the headers, nil when not asked for
+	headers *headerStats
+}
+
+// countRequest counts the request on its stats, grouped by its route
+func (data *statsData) countRequest(
+	reqStats map[string]*reqStat,
+	method string,
+	route string,
+) *reqStat {
+	data.count += 1
+
+	// count method
+	c, _ := data.requestMethodCount[method]
+	data.requestMethodCount[method] = c + 1
+
+	key := method + "_" + route
+	req, ok := reqStats[key]
+	if !ok {
+		req = &reqStat{
+			count:  0,
+			method: method,
+			url:    route,
+			params: make(map[string]*paramStat),
+		}
+		if data.headers != nil {
+			req.headers = make(map[string]int)
+			req.authSchemes = make(map[string]int)
+			req.clients = make(map[string]int)
+		}
+		if data.timeline != nil {
+			req.timeline = newTimeline(data.timeline.bucketMs)
+		}
+		if data.inferBodies {
+			req.bodies = newBodyStat()
+		}
+		reqStats[key] = req
+	}
+	req.count += 1
+
+	return req
+}
+
+// addHeaders counts the headers of a request, the names are case insensitive
+func (r *reqStat) addHeaders(headers map[string]interface{}) {
+	seen := make(map[string]bool)
+	for k := range headers {
+		name := textproto.CanonicalMIMEHeaderKey(k)
+		if !seen[name] {
+			seen[name] = true
+			r.headers[name] += 1
+		}
+	}
+
+	// the scheme is the first word, ie: Bearer, Basic
+	if fields := strings.Fields(sourceHeader(headers, "Authorization")); len(fields) > 0 {
+		r.authSchemes[strings.ToLower(fields[0])] += 1
+	}
+
+	r.clients[userAgentFamily(sourceHeader(headers, "User-Agent"))] += 1
 }
 
 // stats fetches from the input the statistics
-func stats(inputPath string, mostUsedRequestLimit int) (statsData, error) {
+func stats(
+	inputPath string,
+	mostUsedRequestLimit int,
+	routePatterns []string,
+	cardinalityLimit int,
+	fromResults bool,
+	bucketMs int64,
+	inferBodies bool,
+	bodyExamples bool,
+	analyseHeaders bool,
+	redactedHeaders []string,
+) (statsData, error) {
 	data := statsData{
 		count:              0,
 		requests:           []reqStat{},
 		mostUsed:           []reqStat{},
 		requestMethodCount: make(map[string]int),
+		namespaces:         newNamespaceNode("/"),
 	}
 
 	if len(inputPath) == 0 {
 		return data, errors.New("input path is required")
 	}
 
-	file, err := os.Open(inputPath)
+	// the requests are counted over time when a bucket is provided
+	if bucketMs > 0 {
+		if fromResults {
+			return data, errors.New("run results have no time, the timeline needs records")
+		}
+
+		data.timeline = newTimeline(bucketMs)
+	}
+
+	// the bodies are only on the records
+	if inferBodies {
+		if fromResults {
+			return data, errors.New("run results have no bodies, the body schemas need records")
+		}
+
+		data.inferBodies = true
+		data.bodyExamples = bodyExamples
+	}
+
+	// the headers are only on the records
+	if analyseHeaders {
+		if fromResults {
+			return data, errors.New("run results have no headers, the header analysis needs records")
+		}
+
+		data.headers = newHeaderStats(redactedHeaders)
+	}
+
+	paths, err := inputPaths(inputPath)
 	if err != nil {
 		return data, err
 	}
-	defer file.Close()
 
+	routes := parseRoutePatterns(routePatterns)
 	reqStats := make(map[string]*reqStat)
 
-	// for statistics, run a scanner line by line on the file
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		v := strings.ToLower(scanner.Text())
-		sources, err := rawToSource(v)
-		if err != nil {
-			return data, err
-		}
+	// run results have a request per row, with the time it took
+	if fromResults {
+		err = readRunResults(paths, func(r runResult) error {
+			route := r.route
+			if len(route) == 0 {
+				route = routeTemplate(r.url, routes)
+			}
 
-		if len(sources) == 0 {
-			continue
-		}
+			req := data.countRequest(reqStats, r.method, route)
+			if len(r.err) > 0 {
+				req.errors += 1
+				return nil
+			}
+
+			req.timed += 1
+			req.elapsedMs += r.elapsedMs
+
+			return nil
+		})
+	} else {
+		// for statistics, go through the records one by one
+		err = readRecords(paths, func(s source) error {
+			// count the request, grouped by its route so ids don't split them
+			req := data.countRequest(reqStats, s.RequestMethod, routeTemplate(s.RequestUrl, routes))
+
+			if data.timeline != nil {
+				data.timeline.add(s.UnixMs)
+				req.timeline.add(s.UnixMs)
+			}
 
-		// move per source on the raw line (in theory, only 1)
-		for _, s := range sources {
-			data.count += 1
-
-			// count method
-			c, _ := data.requestMethodCount[s.RequestMethod]
-			data.requestMethodCount[s.RequestMethod] = c + 1
-
-			// count the request
-			key := s.RequestMethod + "_" + s.RequestUrl
-			req, ok := reqStats[key]
-			if !ok {
-				req = &reqStat{
-					count:  0,
-					method: s.RequestMethod,
-					url:    s.RequestUrl,
+			if data.inferBodies {
+				req.bodies.add(s)
+			}
+
+			// the requests are grouped by path, the parameters are counted apart
+			for name, v := range sourceQueryValues(s) {
+				param, ok := req.params[name]
+				if !ok {
+					param = &paramStat{name: name, values: make(map[string]int)}
+					req.params[name] = param
 				}
-				reqStats[key] = req
+				param.add(v)
 			}
-			req.count += 1
-		}
-	}
 
-	if err := scanner.Err(); err != nil {
+			if data.headers != nil {
+				req.addHeaders(s.RequestHeaders)
+				data.headers.add(s.RequestHeaders)
+			}
+
+			return nil
+		})
+	}
+	if err != nil {
 		return data, err
 	}
 
-	// find the most used and count the rest
+	// ids that aren't numbers, uuids or hex are found by their cardinality
+	urls := make(map[string]int)
 	for _, v := range reqStats {
-		// cache the stats
-		data.requests = append(data.requests, *v)
+		urls[v.url] += v.count
+	}
+	collapsed := collapseRoutes(urls, routes, cardinalityLimit)
+
+	mergedStats := make(map[string]*reqStat)
+	for _, v := range reqStats {
+		v.url = collapsed[v.url]
 
-		// lets handle the most used now
-		if len(data.mostUsed) < mostUsedRequestLimit {
-			data.mostUsed = append(data.mostUsed, *v)
+		key := v.method + "_" + v.url
+		if existing, ok := mergedStats[key]; ok {
+			existing.merge(v)
 			continue
 		}
+		mergedStats[key] = v
+	}
 
-		newMostUsed := append(data.mostUsed, *v)
-		sort.Sort(reqStatArr(newMostUsed))
-		// sort.Reverse(reqStatArr(newMostUsed))
+	// a small bucket over a long time is widened to keep the timeline readable
+	if data.timeline != nil {
+		firstMs, lastMs, _ := data.timeline.bounds()
+		if fitted := fitBucket(bucketMs, firstMs, lastMs); fitted != bucketMs {
+			log.Println("too many buckets of", bucketLabel(bucketMs), "for the timeline, using", bucketLabel(fitted))
 
-		count := []int{}
-		for _, k := range newMostUsed {
-			count = append(count, k.count)
+			data.timeline = data.timeline.regroup(fitted)
+			for _, v := range mergedStats {
+				v.timeline = v.timeline.regroup(fitted)
+			}
 		}
+	}
+
+	for _, v := range mergedStats {
+		// cache the stats
+		data.requests = append(data.requests, *v)
+		data.namespaces.add(v)
+	}
+
+	// the most used first, the order of the ties is kept stable for the outputs
+	sort.Slice(data.requests, func(i, j int) bool {
+		a, b := data.requests[i], data.requests[j]
+		if a.count != b.count {
+			return a.count > b.count
+		}
+		if a.url != b.url {
+			return a.url < b.url
+		}
+
+		return a.method < b.method
+	})
 
-		data.mostUsed = newMostUsed[1:]
+	// a limit of 0 keeps all of them
+	data.mostUsed = data.requests
+	if mostUsedRequestLimit > 0 && len(data.mostUsed) > mostUsedRequestLimit {
+		data.mostUsed = data.mostUsed[:mostUsedRequestLimit]
 	}
 
 	return data, nil
@@ -0,0 +1,1051 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// paramValueReport is a value of a query parameter with its usage
+type paramValueReport struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// paramReport is the usage of a query parameter on a request
+type paramReport struct {
+	Name     string             `json:"name"`
+	Count    int                `json:"count"`
+	Distinct int                `json:"distinct"`
+	Overflow bool               `json:"overflow,omitempty"`
+	Top      []paramValueReport `json:"top"`
+}
+
+// bucketReport is a bucket of time with its requests
+type bucketReport struct {
+	StartMs int64  `json:"startMs"`
+	Time    string `json:"time"`
+	Count   int    `json:"count"`
+}
+
+// rateReport describes the requests per bucket of a timeline
+type rateReport struct {
+	Buckets       int          `json:"buckets"`
+	Mean          float64      `json:"mean"`
+	MeanPerSecond float64      `json:"meanPerSecond"`
+	Stddev        float64      `json:"stddev"`
+	Peak          bucketReport `json:"peak"`
+	PeakPerSecond float64      `json:"peakPerSecond"`
+	PeakToMean    float64      `json:"peakToMean"`
+	Burstiness    float64      `json:"burstiness"`
+}
+
+// timelineReport is the requests over time, all the timelines of a report
+// share the same buckets, the series only has the buckets with requests
+type timelineReport struct {
+	Bucket   string         `json:"bucket"`
+	BucketMs int64          `json:"bucketMs"`
+	Untimed  int            `json:"untimed,omitempty"`
+	Rate     rateReport     `json:"rate"`
+	Peaks    []bucketReport `json:"peaks"`
+	Series   []bucketReport `json:"series"`
+}
+
+// bodyOutlierReport is a shape of the bodies unlike the majority
+type bodyOutlierReport struct {
+	Count         int         `json:"count"`
+	Reasons       []string    `json:"reasons"`
+	ExampleUnixMs int64       `json:"exampleUnixMs"`
+	Example       interface{} `json:"example"`
+}
+
+// bodyReport is the bodies of a request with their inferred schema
+type bodyReport struct {
+	Count     int                    `json:"count"`
+	Encodings map[string]int         `json:"encodings"`
+	Schema    map[string]interface{} `json:"schema,omitempty"`
+	// OutlierCount is the number of requests with an outlier body
+	OutlierCount   int                 `json:"outlierCount"`
+	Outliers       []bodyOutlierReport `json:"outliers"`
+	ShapesOverflow bool                `json:"shapesOverflow,omitempty"`
+}
+
+// countReport is a value with the number of requests it was on
+type countReport struct {
+	Name  string  `json:"name"`
+	Count int     `json:"count"`
+	Share float64 `json:"share"`
+}
+
+// headerReport is the usage of a header, redacted headers have no values
+type headerReport struct {
+	Name     string             `json:"name"`
+	Count    int                `json:"count"`
+	Share    float64            `json:"share"`
+	Redacted bool               `json:"redacted,omitempty"`
+	Distinct int                `json:"distinct"`
+	Overflow bool               `json:"overflow,omitempty"`
+	Top      []paramValueReport `json:"top"`
+}
+
+// rareClientReport is a client on few requests with the requests it made
+type rareClientReport struct {
+	Client   string   `json:"client"`
+	Count    int      `json:"count"`
+	Requests []string `json:"requests"`
+}
+
+// headersReport is the usage of the headers and the clients of all requests
+type headersReport struct {
+	Headers         []headerReport     `json:"headers"`
+	Clients         []countReport      `json:"clients"`
+	ContentTypes    []countReport      `json:"contentTypes"`
+	AcceptEncodings []countReport      `json:"acceptEncodings"`
+	AuthSchemes     []countReport      `json:"authSchemes"`
+	RareClients     []rareClientReport `json:"rareClients"`
+}
+
+// reqStatReport is a request of the stats
+type reqStatReport struct {
+	Method string `json:"method"`
+	Url    string `json:"url"`
+	Count  int    `json:"count"`
+	// the time and errors are only known for run results
+	AvgElapsedMs   *float64      `json:"avgElapsedMs,omitempty"`
+	TotalElapsedMs *int64        `json:"totalElapsedMs,omitempty"`
+	Errors         int           `json:"errors,omitempty"`
+	Params         []paramReport `json:"params,omitempty"`
+	// Timeline is only there when the stats are bucketed over time
+	Timeline *timelineReport `json:"timeline,omitempty"`
+	// Body is only there when the bodies are inferred
+	Body *bodyReport `json:"body,omitempty"`
+	// Clients and AuthSchemes are only there when the headers are analysed
+	Clients     []countReport `json:"clients,omitempty"`
+	AuthSchemes []countReport `json:"authSchemes,omitempty"`
+}
+
+// namespaceReport is a level of the namespaces with the levels below it
+type namespaceReport struct {
+	Path           string            `json:"path"`
+	Count          int               `json:"count"`
+	Methods        map[string]int    `json:"methods"`
+	AvgElapsedMs   *float64          `json:"avgElapsedMs,omitempty"`
+	TotalElapsedMs *int64            `json:"totalElapsedMs,omitempty"`
+	Errors         int               `json:"errors,omitempty"`
+	Children       []namespaceReport `json:"children,omitempty"`
+}
+
+// statsReport is the whole stats data on the shape it is exported with
+type statsReport struct {
+	Count      int             `json:"count"`
+	Methods    map[string]int  `json:"methods"`
+	Requests   []reqStatReport `json:"requests"`
+	MostUsed   []reqStatReport `json:"mostUsed"`
+	Namespaces namespaceReport `json:"namespaces"`
+	Timeline   *timelineReport `json:"timeline,omitempty"`
+	Headers    *headersReport  `json:"headers,omitempty"`
+}
+
+// paramTopValuesLimit is the number of values reported per parameter
+const paramTopValuesLimit = 5
+
+func reqStatToReport(r reqStat) reqStatReport {
+	report := reqStatReport{
+		Method: r.method,
+		Url:    r.url,
+		Count:  r.count,
+		Errors: r.errors,
+		Params: []paramReport{},
+	}
+
+	if r.timed > 0 {
+		avg := float64(r.elapsedMs) / float64(r.timed)
+		total := r.elapsedMs
+		report.AvgElapsedMs = &avg
+		report.TotalElapsedMs = &total
+	}
+
+	for _, p := range sortedParams(r.params) {
+		param := paramReport{
+			Name:     p.name,
+			Count:    p.count,
+			Distinct: len(p.values),
+			Overflow: p.overflow,
+			Top:      []paramValueReport{},
+		}
+
+		for _, v := range p.topValues(paramTopValuesLimit) {
+			param.Top = append(param.Top, paramValueReport{Value: v, Count: p.values[v]})
+		}
+
+		report.Params = append(report.Params, param)
+	}
+
+	return report
+}
+
+// bodiesToReport converts the bodies of a request with the outliers found
+func bodiesToReport(b *bodyStat, examples bool) *bodyReport {
+	if b == nil {
+		return nil
+	}
+
+	report := &bodyReport{
+		Count:          b.count,
+		Encodings:      b.encodings,
+		Outliers:       []bodyOutlierReport{},
+		ShapesOverflow: b.shapesOverflow,
+	}
+
+	if b.schema.count > 0 {
+		report.Schema = b.schema.schema()
+	}
+
+	for i, o := range b.outliers() {
+		report.OutlierCount += o.shape.count
+		if i >= schemaOutliersLimit {
+			continue
+		}
+
+		// the bodies may have personal data, their values are only kept when asked for
+		example := o.shape.example
+		if !examples {
+			example = redactExample(example)
+		}
+
+		report.Outliers = append(report.Outliers, bodyOutlierReport{
+			Count:         o.shape.count,
+			Reasons:       o.reasons,
+			ExampleUnixMs: o.shape.exampleUnixMs,
+			Example:       example,
+		})
+	}
+
+	return report
+}
+
+// countsToReport converts the counts with their share of the total, the most
+// used first
+func countsToReport(counts map[string]int, total int) []countReport {
+	report := []countReport{}
+	for _, k := range sortedByCount(counts) {
+		share := 0.0
+		if total > 0 {
+			share = float64(counts[k]) / float64(total)
+		}
+
+		report = append(report, countReport{Name: k, Count: counts[k], Share: share})
+	}
+
+	return report
+}
+
+// headersToReport converts the usage of the headers, the rare clients are
+// reported with the requests they made
+func headersToReport(data statsData) *headersReport {
+	h := data.headers
+	if h == nil {
+		return nil
+	}
+
+	report := &headersReport{
+		Headers:         []headerReport{},
+		Clients:         countsToReport(h.clients, data.count),
+		ContentTypes:    countsToReport(h.contentTypes, data.count),
+		AcceptEncodings: countsToReport(h.acceptEncodings, data.count),
+		AuthSchemes:     countsToReport(h.authSchemes, data.count),
+		RareClients:     []rareClientReport{},
+	}
+
+	headerCounts := make(map[string]int)
+	for name, header := range h.headers {
+		headerCounts[name] = header.count
+	}
+
+	for _, c := range countsToReport(headerCounts, data.count) {
+		header := h.headers[c.Name]
+		hr := headerReport{
+			Name:     c.Name,
+			Count:    c.Count,
+			Share:    c.Share,
+			Redacted: h.redact[c.Name],
+			Distinct: len(header.values),
+			Overflow: header.overflow,
+			Top:      []paramValueReport{},
+		}
+
+		for _, v := range header.topValues(paramTopValuesLimit) {
+			hr.Top = append(hr.Top, paramValueReport{Value: v, Count: header.values[v]})
+		}
+
+		report.Headers = append(report.Headers, hr)
+	}
+
+	for _, c := range report.Clients {
+		if c.Share > headerRareClientShare {
+			continue
+		}
+
+		rare := rareClientReport{Client: c.Name, Count: c.Count, Requests: []string{}}
+		for _, r := range data.requests {
+			if r.clients[c.Name] > 0 {
+				rare.Requests = append(rare.Requests, r.method+" "+r.url)
+			}
+		}
+
+		report.RareClients = append(report.RareClients, rare)
+	}
+
+	return report
+}
+
+// bucketToReport converts a bucket, the empty peak of a timeline without
+// requests has no time
+func bucketToReport(b timeBucket, bucketMs int64) bucketReport {
+	if b.startMs == 0 {
+		return bucketReport{}
+	}
+
+	return bucketReport{StartMs: b.startMs, Time: bucketTime(b.startMs, bucketMs), Count: b.count}
+}
+
+// timelineToReport converts the timeline from the first to the last bucket
+func timelineToReport(t *timeline, firstMs int64, lastMs int64) *timelineReport {
+	if t == nil {
+		return nil
+	}
+
+	series := t.series(firstMs, lastMs)
+	summary := summarizeRate(series, bucketsBetween(firstMs, lastMs, t.bucketMs))
+
+	report := &timelineReport{
+		Bucket:   bucketLabel(t.bucketMs),
+		BucketMs: t.bucketMs,
+		Untimed:  t.untimed,
+		Rate: rateReport{
+			Buckets:       summary.buckets,
+			Mean:          summary.mean,
+			MeanPerSecond: perSecond(summary.mean, t.bucketMs),
+			Stddev:        summary.stddev,
+			Peak:          bucketToReport(summary.peak, t.bucketMs),
+			PeakPerSecond: perSecond(float64(summary.peak.count), t.bucketMs),
+			Burstiness:    summary.burstiness,
+		},
+		Peaks:  []bucketReport{},
+		Series: []bucketReport{},
+	}
+
+	if summary.mean > 0 {
+		report.Rate.PeakToMean = float64(summary.peak.count) / summary.mean
+	}
+
+	for _, b := range peakWindows(series, timelinePeaksLimit) {
+		report.Peaks = append(report.Peaks, bucketToReport(b, t.bucketMs))
+	}
+
+	for _, b := range series {
+		report.Series = append(report.Series, bucketToReport(b, t.bucketMs))
+	}
+
+	return report
+}
+
+func namespaceToReport(n *namespaceNode) namespaceReport {
+	report := namespaceReport{
+		Path:    n.path,
+		Count:   n.count,
+		Methods: n.methods,
+		Errors:  n.errors,
+	}
+
+	if n.timed > 0 {
+		avg := n.avgElapsedMs()
+		total := n.elapsedMs
+		report.AvgElapsedMs = &avg
+		report.TotalElapsedMs = &total
+	}
+
+	for _, c := range n.sortedChildren() {
+		report.Children = append(report.Children, namespaceToReport(c))
+	}
+
+	return report
+}
+
+// statsToReport converts the stats data to its exported shape
+func statsToReport(data statsData) statsReport {
+	report := statsReport{
+		Count:      data.count,
+		Methods:    data.requestMethodCount,
+		Requests:   []reqStatReport{},
+		MostUsed:   []reqStatReport{},
+		Namespaces: namespaceToReport(data.namespaces),
+		Headers:    headersToReport(data),
+	}
+
+	// the requests are bucketed over the whole time of the stats
+	firstMs, lastMs := int64(0), int64(0)
+	if data.timeline != nil {
+		firstMs, lastMs, _ = data.timeline.bounds()
+		report.Timeline = timelineToReport(data.timeline, firstMs, lastMs)
+	}
+
+	toReport := func(r reqStat) reqStatReport {
+		req := reqStatToReport(r)
+		req.Timeline = timelineToReport(r.timeline, firstMs, lastMs)
+		req.Body = bodiesToReport(r.bodies, data.bodyExamples)
+		if data.headers != nil {
+			req.Clients = countsToReport(r.clients, r.count)
+			req.AuthSchemes = countsToReport(r.authSchemes, r.count)
+		}
+		return req
+	}
+
+	for _, r := range data.requests {
+		report.Requests = append(report.Requests, toReport(r))
+	}
+
+	for _, r := range data.mostUsed {
+		report.MostUsed = append(report.MostUsed, toReport(r))
+	}
+
+	return report
+}
+
+// sortedByCount returns the keys sorted by count, the most used first
+func sortedByCount(counts map[string]int) []string {
+	keys := []string{}
+	for k := range counts {
+		keys = append(keys, k)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] == counts[keys[j]] {
+			return keys[i] < keys[j]
+		}
+
+		return counts[keys[i]] > counts[keys[j]]
+	})
+
+	return keys
+}
+
+// valuesSummary describes the distinct and most used values on a line
+func valuesSummary(distinct int, overflow bool, top []paramValueReport) string {
+	distinctText := fmt.Sprintf("%d", distinct)
+	if overflow {
+		distinctText = fmt.Sprintf("more than %d", paramValuesLimit)
+	}
+
+	list := []string{}
+	for _, v := range top {
+		list = append(list, fmt.Sprintf("%s (%d)", v.Value, v.Count))
+	}
+
+	return fmt.Sprintf("%s distinct values, top: %s", distinctText, strings.Join(list, ", "))
+}
+
+// paramSummary describes the usage of a parameter on a line
+func paramSummary(p paramReport) string {
+	return fmt.Sprintf("on %d requests, %s", p.Count, valuesSummary(p.Distinct, p.Overflow, p.Top))
+}
+
+// rateSummaryText describes the rate of a timeline on a line
+func rateSummaryText(t *timelineReport) string {
+	return fmt.Sprintf(
+		"mean %.2f per %s (%.2f/s), peak %d at %s (%.2f/s), peak/mean %.2f, burstiness %.2f",
+		t.Rate.Mean,
+		t.Bucket,
+		t.Rate.MeanPerSecond,
+		t.Rate.Peak.Count,
+		t.Rate.Peak.Time,
+		t.Rate.PeakPerSecond,
+		t.Rate.PeakToMean,
+		t.Rate.Burstiness,
+	)
+}
+
+// timelineToText returns the chart of the timeline with its rate and peaks
+func timelineToText(data statsData, t *timelineReport) []string {
+	lines := []string{fmt.Sprintf("timeline (per %s, utc):", t.Bucket)}
+	if len(t.Series) == 0 {
+		return append(lines, "no requests with a time")
+	}
+
+	firstMs, lastMs, _ := data.timeline.bounds()
+	lines = append(lines, chartLines(data.timeline, firstMs, lastMs)...)
+	lines = append(lines, "", "rate: "+rateSummaryText(t))
+	if t.Untimed > 0 {
+		lines = append(lines, fmt.Sprintf("requests without a time: %d", t.Untimed))
+	}
+
+	lines = append(lines, "peaks:")
+	for _, b := range t.Peaks {
+		lines = append(lines, fmt.Sprintf("    %s %d", b.Time, b.Count))
+	}
+
+	return lines
+}
+
+// encodingsText lists the encodings of the bodies, the most used first
+func encodingsText(encodings map[string]int) string {
+	list := []string{}
+	for _, e := range sortedByCount(encodings) {
+		list = append(list, fmt.Sprintf("%s %d", e, encodings[e]))
+	}
+
+	return strings.Join(list, ", ")
+}
+
+// outlierText describes an outlier body on a line
+func outlierText(o bodyOutlierReport) string {
+	example, _ := json.Marshal(o.Example)
+
+	return fmt.Sprintf(
+		"%d requests, %s, example at %s: %s",
+		o.Count,
+		strings.Join(o.Reasons, ", "),
+		bucketTime(o.ExampleUnixMs, 1),
+		string(example),
+	)
+}
+
+// bodyToText returns the schema of the bodies and their outliers, indented
+// under the request
+func bodyToText(b *bodyReport) []string {
+	if b.Count == 0 {
+		return []string{"    bodies: none"}
+	}
+
+	lines := []string{fmt.Sprintf("    bodies: %d (%s)", b.Count, encodingsText(b.Encodings))}
+
+	if b.Schema != nil {
+		raw, _ := json.MarshalIndent(b.Schema, "        ", "  ")
+		lines = append(lines, "    body schema:", "        "+string(raw))
+	}
+
+	for _, o := range b.Outliers {
+		lines = append(lines, "    body outlier: "+outlierText(o))
+	}
+
+	if b.OutlierCount > 0 {
+		lines = append(lines, fmt.Sprintf("    requests with an outlier body: %d", b.OutlierCount))
+	}
+
+	return lines
+}
+
+// countsText lists the counts with their share on a line
+func countsText(counts []countReport) string {
+	list := []string{}
+	for _, c := range counts {
+		list = append(list, fmt.Sprintf("%s %.1f%%", c.Name, c.Share*100))
+	}
+
+	if len(list) == 0 {
+		return "none"
+	}
+
+	return strings.Join(list, ", ")
+}
+
+// headerSummary describes the usage of a header on a line
+func headerSummary(h headerReport) string {
+	summary := fmt.Sprintf("on %d requests (%.1f%%)", h.Count, h.Share*100)
+	if h.Redacted {
+		return summary + ", redacted"
+	}
+
+	return summary + ", " + valuesSummary(h.Distinct, h.Overflow, h.Top)
+}
+
+// headersToText returns the headers, clients and their rare ones
+func headersToText(h *headersReport) []string {
+	lines := []string{"headers:"}
+	for _, header := range h.Headers {
+		lines = append(lines, fmt.Sprintf("    %s: %s", header.Name, headerSummary(header)))
+	}
+
+	lines = append(lines,
+		"clients: "+countsText(h.Clients),
+		"content types: "+countsText(h.ContentTypes),
+		"accept encodings: "+countsText(h.AcceptEncodings),
+		"auth schemes: "+countsText(h.AuthSchemes),
+	)
+
+	if len(h.RareClients) > 0 {
+		lines = append(lines, "rare clients:")
+		for _, c := range h.RareClients {
+			lines = append(lines, fmt.Sprintf(
+				"    %s (%d requests): %s",
+				c.Client,
+				c.Count,
+				strings.Join(c.Requests, ", "),
+			))
+		}
+	}
+
+	return lines
+}
+
+// statsToText converts the stats to the text we print
+func statsToText(data statsData) string {
+	report := statsToReport(data)
+	lines := []string{fmt.Sprintf("count: %d", report.Count), ""}
+
+	// methods
+	for _, m := range sortedByCount(report.Methods) {
+		lines = append(lines, fmt.Sprintf("method count: %s %d", m, report.Methods[m]))
+	}
+
+	lines = append(lines, "")
+
+	// requests over time
+	if report.Timeline != nil {
+		lines = append(lines, timelineToText(data, report.Timeline)...)
+		lines = append(lines, "")
+	}
+
+	// headers and clients
+	if report.Headers != nil {
+		lines = append(lines, headersToText(report.Headers)...)
+		lines = append(lines, "")
+	}
+
+	// used requests
+	for i, r := range report.MostUsed {
+		lines = append(lines, fmt.Sprintf("most used request (%d): %s %s %d", i+1, r.Method, r.Url, r.Count))
+
+		if r.AvgElapsedMs != nil {
+			lines = append(lines, fmt.Sprintf("    avg elapsed time: %.0f ms", *r.AvgElapsedMs))
+		}
+		if r.Errors > 0 {
+			lines = append(lines, fmt.Sprintf("    errors: %d", r.Errors))
+		}
+		if r.Timeline != nil && len(r.Timeline.Series) > 0 {
+			lines = append(lines, "    rate: "+rateSummaryText(r.Timeline))
+		}
+		if r.Body != nil {
+			lines = append(lines, bodyToText(r.Body)...)
+		}
+		if r.Clients != nil {
+			lines = append(lines, "    clients: "+countsText(r.Clients))
+			lines = append(lines, "    auth schemes: "+countsText(r.AuthSchemes))
+		}
+
+		for _, p := range r.Params {
+			lines = append(lines, fmt.Sprintf("    param %s: %s", p.Name, paramSummary(p)))
+		}
+	}
+
+	lines = append(lines, "")
+
+	// requests rolled up per namespace
+	lines = append(lines, "namespaces:")
+	lines = append(lines, data.namespaces.lines(0)...)
+
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// statsToJson converts the stats to json
+func statsToJson(data statsData) (string, error) {
+	raw, err := json.MarshalIndent(statsToReport(data), "", "  ")
+	return string(raw) + "\n", err
+}
+
+// statsCsvColumns are the columns of the stats csv, a row per request
+var statsCsvColumns = []string{
+	"rank",
+	"method",
+	"url",
+	"count",
+	"share",
+	"avg_elapsed_ms",
+	"total_elapsed_ms",
+	"errors",
+	"params",
+	"body_schema",
+	"body_outliers",
+	"clients",
+	"mean_per_second",
+	"peak_per_second",
+	"peak_at",
+	"burstiness",
+}
+
+// statsToCsv converts the stats to csv, a row per request with its rank so
+// the top ones are the first rows
+func statsToCsv(data statsData) (string, error) {
+	report := statsToReport(data)
+
+	buf := &bytes.Buffer{}
+	w := csv.NewWriter(buf)
+	if err := w.Write(statsCsvColumns); err != nil {
+		return "", err
+	}
+
+	for i, r := range report.Requests {
+		avg, total := "", ""
+		if r.AvgElapsedMs != nil {
+			avg = fmt.Sprintf("%.2f", *r.AvgElapsedMs)
+			total = fmt.Sprintf("%d", *r.TotalElapsedMs)
+		}
+
+		params := []string{}
+		for _, p := range r.Params {
+			params = append(params, p.Name)
+		}
+
+		// the bodies and clients are only known when asked for
+		schema, outliers, clients := "", "", ""
+		meanRate, peakRate, peakAt, burstiness := "", "", "", ""
+		if r.Clients != nil {
+			clients = countsText(r.Clients)
+		}
+		if r.Body != nil {
+			if r.Body.Schema != nil {
+				raw, err := json.Marshal(r.Body.Schema)
+				if err != nil {
+					return "", err
+				}
+				schema = string(raw)
+			}
+			outliers = fmt.Sprintf("%d", r.Body.OutlierCount)
+		}
+		if r.Timeline != nil && r.Timeline.Rate.Peak.Count > 0 {
+			meanRate = fmt.Sprintf("%.2f", r.Timeline.Rate.MeanPerSecond)
+			peakRate = fmt.Sprintf("%.2f", r.Timeline.Rate.PeakPerSecond)
+			peakAt = r.Timeline.Rate.Peak.Time
+			burstiness = fmt.Sprintf("%.2f", r.Timeline.Rate.Burstiness)
+		}
+
+		row := []string{
+			fmt.Sprintf("%d", i+1),
+			r.Method,
+			r.Url,
+			fmt.Sprintf("%d", r.Count),
+			fmt.Sprintf("%.4f", float64(r.Count)/float64(report.Count)),
+			avg,
+			total,
+			fmt.Sprintf("%d", r.Errors),
+			strings.Join(params, " "),
+			schema,
+			outliers,
+			clients,
+			meanRate,
+			peakRate,
+			peakAt,
+			burstiness,
+		}
+
+		if err := w.Write(row); err != nil {
+			return "", err
+		}
+	}
+
+	w.Flush()
+	return buf.String(), w.Error()
+}
+
+// timelineToCsv converts the timeline to csv, a row per bucket from the first
+// to the last with the total and a column for each of the most used requests
+func timelineToCsv(data statsData) (string, error) {
+	if data.timeline == nil {
+		return "", errors.New("the csv buckets need a timeline, set a bucket")
+	}
+
+	buf := &bytes.Buffer{}
+	w := csv.NewWriter(buf)
+
+	columns := []string{"time", "start_ms", "total"}
+	for _, r := range data.mostUsed {
+		columns = append(columns, r.method+" "+r.url)
+	}
+	if err := w.Write(columns); err != nil {
+		return "", err
+	}
+
+	// the timeline only has the buckets with requests, the others are empty rows
+	bucketMs := data.timeline.bucketMs
+	firstMs, lastMs, ok := data.timeline.bounds()
+	for k := firstMs; ok && k <= lastMs; k += bucketMs {
+		row := []string{
+			bucketTime(k, bucketMs),
+			fmt.Sprintf("%d", k),
+			fmt.Sprintf("%d", data.timeline.counts[k]),
+		}
+		for _, r := range data.mostUsed {
+			row = append(row, fmt.Sprintf("%d", r.timeline.counts[k]))
+		}
+
+		if err := w.Write(row); err != nil {
+			return "", err
+		}
+	}
+
+	w.Flush()
+	return buf.String(), w.Error()
+}
+
+// markdownEscape keeps the values from breaking the tables
+func markdownEscape(value string) string {
+	return strings.ReplaceAll(value, "|", `\|`)
+}
+
+// statsToMarkdown converts the stats to markdown, ready for PR comments
+func statsToMarkdown(data statsData) string {
+	report := statsToReport(data)
+	lines := []string{
+		"## Request stats",
+		"",
+		fmt.Sprintf("**%d** requests", report.Count),
+		"",
+		"### Methods",
+		"",
+		"| Method | Count | Share |",
+		"| --- | ---: | ---: |",
+	}
+
+	for _, m := range sortedByCount(report.Methods) {
+		lines = append(lines, fmt.Sprintf(
+			"| %s | %d | %.1f%% |",
+			markdownEscape(m),
+			report.Methods[m],
+			float64(report.Methods[m])*100/float64(report.Count),
+		))
+	}
+
+	lines = append(lines,
+		"",
+		fmt.Sprintf("### Most used requests (top %d)", len(report.MostUsed)),
+		"",
+		"| # | Method | Url | Count | Avg elapsed | Errors | Params |",
+		"| ---: | --- | --- | ---: | ---: | ---: | --- |",
+	)
+
+	for i, r := range report.MostUsed {
+		avg := ""
+		if r.AvgElapsedMs != nil {
+			avg = fmt.Sprintf("%.0f ms", *r.AvgElapsedMs)
+		}
+
+		params := []string{}
+		for _, p := range r.Params {
+			params = append(params, "`"+p.Name+"`")
+		}
+
+		lines = append(lines, fmt.Sprintf(
+			"| %d | %s | `%s` | %d | %s | %d | %s |",
+			i+1,
+			markdownEscape(r.Method),
+			markdownEscape(r.Url),
+			r.Count,
+			avg,
+			r.Errors,
+			markdownEscape(strings.Join(params, ", ")),
+		))
+	}
+
+	if report.Timeline != nil {
+		lines = append(lines, timelineToMarkdown(data, report)...)
+	}
+
+	if report.Headers != nil {
+		lines = append(lines, headersToMarkdown(report)...)
+	}
+
+	if len(report.MostUsed) > 0 && report.MostUsed[0].Body != nil {
+		lines = append(lines, bodiesToMarkdown(report)...)
+	}
+
+	lines = append(lines, "", "### Namespaces", "", "```")
+	lines = append(lines, data.namespaces.lines(0)...)
+	lines = append(lines, "```")
+
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// timelineToMarkdown returns the chart and rates of the timeline
+func timelineToMarkdown(data statsData, report statsReport) []string {
+	t := report.Timeline
+	lines := []string{"", fmt.Sprintf("### Traffic over time (per %s, utc)", t.Bucket), ""}
+	if len(t.Series) == 0 {
+		return append(lines, "No requests with a time")
+	}
+
+	firstMs, lastMs, _ := data.timeline.bounds()
+	lines = append(lines, "```")
+	lines = append(lines, chartLines(data.timeline, firstMs, lastMs)...)
+	lines = append(lines, "```", "", "Rate: "+rateSummaryText(t), "")
+
+	lines = append(lines,
+		"| # | Method | Url | Mean /s | Peak /s | Peak at | Burstiness |",
+		"| ---: | --- | --- | ---: | ---: | --- | ---: |",
+	)
+	for i, r := range report.MostUsed {
+		lines = append(lines, fmt.Sprintf(
+			"| %d | %s | `%s` | %.2f | %.2f | %s | %.2f |",
+			i+1,
+			markdownEscape(r.Method),
+			markdownEscape(r.Url),
+			r.Timeline.Rate.MeanPerSecond,
+			r.Timeline.Rate.PeakPerSecond,
+			r.Timeline.Rate.Peak.Time,
+			r.Timeline.Rate.Burstiness,
+		))
+	}
+
+	return lines
+}
+
+// headersToMarkdown returns the headers, the clients and the clients of the
+// most used requests
+func headersToMarkdown(report statsReport) []string {
+	h := report.Headers
+	lines := []string{
+		"",
+		"### Headers",
+		"",
+		"| Header | Requests | Share | Values |",
+		"| --- | ---: | ---: | --- |",
+	}
+
+	for _, header := range h.Headers {
+		values := "redacted"
+		if !header.Redacted {
+			values = valuesSummary(header.Distinct, header.Overflow, header.Top)
+		}
+
+		lines = append(lines, fmt.Sprintf(
+			"| `%s` | %d | %.1f%% | %s |",
+			markdownEscape(header.Name),
+			header.Count,
+			header.Share*100,
+			markdownEscape(values),
+		))
+	}
+
+	lines = append(lines,
+		"",
+		"### Clients",
+		"",
+		"| Client | Requests | Share |",
+		"| --- | ---: | ---: |",
+	)
+	for _, c := range h.Clients {
+		lines = append(lines, fmt.Sprintf("| %s | %d | %.1f%% |", markdownEscape(c.Name), c.Count, c.Share*100))
+	}
+
+	lines = append(lines,
+		"",
+		"- Content types: "+markdownEscape(countsText(h.ContentTypes)),
+		"- Accept encodings: "+markdownEscape(countsText(h.AcceptEncodings)),
+		"- Auth schemes: "+markdownEscape(countsText(h.AuthSchemes)),
+	)
+
+	if len(h.RareClients) > 0 {
+		lines = append(lines, "", "Rare clients:", "")
+		for _, c := range h.RareClients {
+			lines = append(lines, fmt.Sprintf(
+				"- %s (%d requests): %s",
+				markdownEscape(c.Client),
+				c.Count,
+				markdownEscape(strings.Join(c.Requests, ", ")),
+			))
+		}
+	}
+
+	lines = append(lines,
+		"",
+		"| # | Method | Url | Clients | Auth schemes |",
+		"| ---: | --- | --- | --- | --- |",
+	)
+	for i, r := range report.MostUsed {
+		lines = append(lines, fmt.Sprintf(
+			"| %d | %s | `%s` | %s | %s |",
+			i+1,
+			markdownEscape(r.Method),
+			markdownEscape(r.Url),
+			markdownEscape(countsText(r.Clients)),
+			markdownEscape(countsText(r.AuthSchemes)),
+		))
+	}
+
+	return lines
+}
+
+// bodiesToMarkdown returns the schema and outliers of the bodies of the most
+// used requests
+func bodiesToMarkdown(report statsReport) []string {
+	lines := []string{"", "### Request bodies"}
+
+	for _, r := range report.MostUsed {
+		if r.Body.Count == 0 {
+			continue
+		}
+
+		lines = append(lines,
+			"",
+			fmt.Sprintf("#### %s `%s`", r.Method, markdownEscape(r.Url)),
+			"",
+			fmt.Sprintf("%d bodies (%s)", r.Body.Count, encodingsText(r.Body.Encodings)),
+		)
+
+		if r.Body.Schema != nil {
+			raw, _ := json.MarshalIndent(r.Body.Schema, "", "  ")
+			lines = append(lines, "", "```json", string(raw), "```")
+		}
+
+		if len(r.Body.Outliers) > 0 {
+			lines = append(lines, "", fmt.Sprintf("Outliers, on %d requests:", r.Body.OutlierCount), "")
+			for _, o := range r.Body.Outliers {
+				lines = append(lines, "- "+outlierText(o))
+			}
+		}
+	}
+
+	return lines
+}
+
+// writeStats writes the stats on the format, an empty output path writes to
+// the stdout
+func writeStats(data statsData, outputPath string, format string, bucketRows bool) error {
+	var output string
+	var err error
+	switch strings.ToLower(format) {
+	case "", "text":
+		output = statsToText(data)
+		break
+	case "json":
+		output, err = statsToJson(data)
+		break
+	case "csv":
+		if bucketRows {
+			output, err = timelineToCsv(data)
+			break
+		}
+		output, err = statsToCsv(data)
+		break
+	case "markdown", "md":
+		output = statsToMarkdown(data)
+		break
+	default:
+		return errors.New("stats format not supported: " + format)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if len(outputPath) == 0 {
+		_, err := os.Stdout.WriteString(output)
+		return err
+	}
+
+	return os.WriteFile(outputPath, []byte(output), 0644)
+}
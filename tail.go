@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// redisConsumerName identifies this host on the consumer group, it is kept
+// between runs so the entries left pending are picked up again
+func redisConsumerName() string {
+	host, err := os.Hostname()
+	if err != nil {
+		return "request_analyser"
+	}
+
+	return host
+}
+
+// queueRawRecord converts the raw record and queues its requests, done is
+// called once all of them were executed (or right away if none is queued)
+func queueRawRecord(q *queue, raw string, ignorePatterns []string, done func()) error {
+	sources, err := rawToSource(raw)
+	if err != nil {
+		done()
+		return err
+	}
+
+	queued := []source{}
+	for _, s := range sources {
+		if !isSourceFiltered(s, ignorePatterns) {
+			queued = append(queued, s)
+		}
+	}
+
+	if len(queued) == 0 {
+		done()
+		return nil
+	}
+
+	remaining := int32(len(queued))
+	for _, s := range queued {
+		q.addToQueue(s, func(err error) {
+			if atomic.AddInt32(&remaining, -1) == 0 {
+				done()
+			}
+		})
+	}
+
+	return nil
+}
+
+// tailRedisStream reads the new entries of a stream with a consumer group
+// and queues them, entries are acknowledged after being executed
+func tailRedisStream(
+	ctx context.Context,
+	rdb *redis.Client,
+	q *queue,
+	key string,
+	group string,
+	ignorePatterns []string,
+) error {
+	// the group may already exist, that is fine
+	err := rdb.XGroupCreateMkStream(key, group, "$").Err()
+	if err != nil && !strings.Contains(err.Error(), "BUSYGROUP") {
+		return err
+	}
+
+	consumer := redisConsumerName()
+	log.Println("tailing stream", key, "on group", group, "as", consumer)
+
+	// start with the entries pending for us from a previous run, then the new
+	// ones, the pending list is read once as the entries stay on it until acked
+	id := "0"
+
+	for ctx.Err() == nil {
+		// we might be loading a lot into memory, lets slow down a bit
+		if q.getJobCount() > 5000 {
+			time.Sleep(time.Second * 2)
+			continue
+		}
+
+		streams, err := rdb.XReadGroup(&redis.XReadGroupArgs{
+			Group:    group,
+			Consumer: consumer,
+			Streams:  []string{key, id},
+			Count:    100,
+			Block:    time.Second * 2,
+		}).Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		count := 0
+		for _, stream := range streams {
+			for _, msg := range stream.Messages {
+				count += 1
+
+				// the next page of the pending list starts after this entry
+				msgId := msg.ID
+				if id != ">" {
+					id = msgId
+				}
+
+				ack := func() {
+					if err := rdb.XAck(key, group, msgId).Err(); err != nil {
+						log.Println("failed to acknowledge", msgId, err)
+					}
+				}
+
+				if err := queueRawRecord(q, redisStreamMessageToRaw(msg), ignorePatterns, ack); err != nil {
+					log.Println("invalid record on", msgId, err)
+				}
+			}
+		}
+
+		// no more pending entries, time for the new ones
+		if id != ">" && count == 0 {
+			id = ">"
+		}
+	}
+
+	return nil
+}
+
+// tailRedisChannel subscribes to a pub/sub channel and queues every message
+func tailRedisChannel(
+	ctx context.Context,
+	rdb *redis.Client,
+	q *queue,
+	channel string,
+	ignorePatterns []string,
+) error {
+	pubsub := rdb.Subscribe(channel)
+	defer pubsub.Close()
+
+	// make sure the subscription is in place before waiting for messages
+	if _, err := pubsub.Receive(); err != nil {
+		return err
+	}
+
+	log.Println("tailing channel", channel)
+
+	messages := pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg, ok := <-messages:
+			if !ok {
+				return nil
+			}
+
+			if err := queueRawRecord(q, msg.Payload, ignorePatterns, func() {}); err != nil {
+				log.Println("invalid record on", channel, err)
+			}
+		}
+	}
+}
+
+// runRedisTail keeps running the requests arriving on a redis stream or
+// channel until the context is done
+func runRedisTail(
+	ctx context.Context,
+	srcRaw string,
+	q *queue,
+	group string,
+	ignorePatterns []string,
+) error {
+	src := parseRedisSource(srcRaw)
+
+	opts, err := redis.ParseURL(src.url)
+	if err != nil {
+		return err
+	}
+
+	rdb := redis.NewClient(opts)
+	defer rdb.Close()
+
+	switch src.mode {
+	case "stream":
+		err = tailRedisStream(ctx, rdb, q, src.key, group, ignorePatterns)
+		break
+	case "channel":
+		err = tailRedisChannel(ctx, rdb, q, src.key, ignorePatterns)
+		break
+	default:
+		return errors.New("only redis streams and channels can be run, use parse for the rest")
+	}
+
+	// let the queued requests finish before leaving
+	q.wait()
+
+	return err
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// fakeStream is a redis server with a single stream and consumer group,
+// enough of XGROUP, XREADGROUP and XACK for the stream tail
+type fakeStream struct {
+	mu            sync.Mutex
+	ids           []string
+	values        map[string]string
+	lastDelivered int
+	// pending has the delivered entries that weren't acked yet
+	pending   map[string]bool
+	delivered map[string]int
+	acked     map[string]int
+}
+
+func newFakeStream() *fakeStream {
+	return &fakeStream{
+		values:    make(map[string]string),
+		pending:   make(map[string]bool),
+		delivered: make(map[string]int),
+		acked:     make(map[string]int),
+	}
+}
+
+func (f *fakeStream) addEntry(id string, record string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.ids = append(f.ids, id)
+	f.values[id] = record
+}
+
+// deliverPending marks the first entries as delivered by a previous run
+func (f *fakeStream) deliverPending(count int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, id := range f.ids[:count] {
+		f.pending[id] = true
+	}
+	f.lastDelivered = count
+}
+
+// streamIdLess compares two stream ids, ie: 2-0 < 10-0
+func streamIdLess(a string, b string) bool {
+	parse := func(id string) (int64, int64) {
+		arr := strings.SplitN(id, "-", 2)
+		ms, _ := strconv.ParseInt(arr[0], 10, 64)
+		seq := int64(0)
+		if len(arr) > 1 {
+			seq, _ = strconv.ParseInt(arr[1], 10, 64)
+		}
+		return ms, seq
+	}
+
+	aMs, aSeq := parse(a)
+	bMs, bSeq := parse(b)
+	if aMs == bMs {
+		return aSeq < bSeq
+	}
+
+	return aMs < bMs
+}
+
+// readGroup returns the new entries for ">" or the pending ones after the id
+func (f *fakeStream) readGroup(id string, count int) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	entries := []string{}
+	if id == ">" {
+		for f.lastDelivered < len(f.ids) && len(entries) < count {
+			next := f.ids[f.lastDelivered]
+			f.lastDelivered += 1
+			f.pending[next] = true
+			f.delivered[next] += 1
+			entries = append(entries, next)
+		}
+		return entries
+	}
+
+	for _, entryId := range f.ids {
+		if f.pending[entryId] && streamIdLess(id, entryId) && len(entries) < count {
+			f.delivered[entryId] += 1
+			entries = append(entries, entryId)
+		}
+	}
+
+	return entries
+}
+
+func (f *fakeStream) ack(id string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.acked[id] += 1
+	if !f.pending[id] {
+		return 0
+	}
+
+	delete(f.pending, id)
+	return 1
+}
+
+func (f *fakeStream) done() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return len(f.pending) == 0 && f.lastDelivered == len(f.ids)
+}
+
+// readCommand reads a command sent as a resp array of bulk strings
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	count, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil {
+		return nil, err
+	}
+
+	args := []string{}
+	for i := 0; i < count; i++ {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+
+		size, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+		if err != nil {
+			return nil, err
+		}
+
+		arg := make([]byte, size+2)
+		if _, err := io.ReadFull(r, arg); err != nil {
+			return nil, err
+		}
+		args = append(args, string(arg[:size]))
+	}
+
+	return args, nil
+}
+
+func bulkString(s string) string {
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(s), s)
+}
+
+func (f *fakeStream) reply(args []string) string {
+	switch strings.ToUpper(args[0]) {
+	case "XGROUP":
+		return "+OK\r\n"
+	case "XACK":
+		acked := 0
+		for _, id := range args[3:] {
+			acked += f.ack(id)
+		}
+		return fmt.Sprintf(":%d\r\n", acked)
+	case "XREADGROUP":
+		count := 100
+		block := time.Duration(0)
+		key := ""
+		id := ""
+		for i := 0; i < len(args); i++ {
+			switch strings.ToUpper(args[i]) {
+			case "COUNT":
+				count, _ = strconv.Atoi(args[i+1])
+				break
+			case "BLOCK":
+				ms, _ := strconv.Atoi(args[i+1])
+				block = time.Duration(ms) * time.Millisecond
+				break
+			case "STREAMS":
+				key = args[i+1]
+				id = args[i+2]
+				break
+			}
+		}
+
+		entries := f.readGroup(id, count)
+		if len(entries) == 0 && id == ">" {
+			// no need to wait the whole block on the tests
+			if block > 50*time.Millisecond {
+				block = 50 * time.Millisecond
+			}
+			time.Sleep(block)
+			return "*-1\r\n"
+		}
+
+		reply := "*1\r\n*2\r\n" + bulkString(key) + fmt.Sprintf("*%d\r\n", len(entries))
+		for _, entryId := range entries {
+			f.mu.Lock()
+			record := f.values[entryId]
+			f.mu.Unlock()
+
+			reply += "*2\r\n" + bulkString(entryId) + "*2\r\n" + bulkString("record") + bulkString(record)
+		}
+		return reply
+	}
+
+	return "-ERR unknown command\r\n"
+}
+
+func (f *fakeStream) serve(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+
+			go func(conn net.Conn) {
+				defer conn.Close()
+
+				r := bufio.NewReader(conn)
+				for {
+					args, err := readCommand(r)
+					if err != nil || len(args) == 0 {
+						return
+					}
+
+					if _, err := conn.Write([]byte(f.reply(args))); err != nil {
+						return
+					}
+				}
+			}(conn)
+		}
+	}()
+
+	return ln.Addr().String()
+}
+
+func TestTailRedisStreamRunsEachEntryOnce(t *testing.T) {
+	var mu sync.Mutex
+	hits := make(map[string]int)
+
+	// slow requests leave the entries pending while the stream is read again
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits[r.URL.Path] += 1
+		mu.Unlock()
+
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	stream := newFakeStream()
+	for i := 1; i <= 6; i++ {
+		stream.addEntry(
+			fmt.Sprintf("%d-0", i),
+			fmt.Sprintf(`{"unixMs":%d,"requestMethod":"GET","requestUrl":"entry%d","requestHeaders":{}}`, i, i),
+		)
+	}
+	// the first entries were left pending by a previous run
+	stream.deliverPending(3)
+
+	rdb := redis.NewClient(&redis.Options{Addr: stream.serve(t)})
+	defer rdb.Close()
+
+	q := newQueue(server.URL, 0, 10, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	tailed := make(chan error, 1)
+	go func() {
+		tailed <- tailRedisStream(ctx, rdb, q, "records", "group", []string{})
+	}()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for !stream.done() && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+	}
+	// give a replayed entry the time to show up
+	time.Sleep(300 * time.Millisecond)
+
+	cancel()
+	if err := <-tailed; err != nil {
+		t.Fatal(err)
+	}
+	q.wait()
+
+	if !stream.done() {
+		t.Fatalf("entries left pending: %v", stream.pending)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for i := 1; i <= 6; i++ {
+		id := fmt.Sprintf("%d-0", i)
+		path := fmt.Sprintf("/entry%d", i)
+
+		if hits[path] != 1 {
+			t.Errorf("%s ran %d times, want 1", id, hits[path])
+		}
+		if stream.acked[id] != 1 {
+			t.Errorf("%s acked %d times, want 1", id, stream.acked[id])
+		}
+	}
+}
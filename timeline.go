@@ -0,0 +1,314 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// timelineChartRows caps the rows of the chart, consecutive buckets are
+// summed into a row when there are more
+const timelineChartRows = 60
+
+// timelineChartWidth is the width of the longest bar of the chart
+const timelineChartWidth = 50
+
+// timelinePeaksLimit is the number of peak windows reported
+const timelinePeaksLimit = 5
+
+// timelineMaxBuckets caps the buckets between the first and the last request,
+// the bucket is widened when a small one would need more
+const timelineMaxBuckets = 10000
+
+// timeline counts the requests per bucket of time, only the buckets with
+// requests are kept
+type timeline struct {
+	bucketMs int64
+	// counts has the requests per start of bucket, in unix ms, the buckets
+	// missing are empty
+	counts map[int64]int
+	// untimed is the number of requests without a time
+	untimed int
+}
+
+// timeBucket is a bucket of the timeline with its requests
+type timeBucket struct {
+	startMs int64
+	count   int
+}
+
+// rateSummary describes the requests per bucket of a timeline
+type rateSummary struct {
+	buckets int
+	total   int
+	mean    float64
+	stddev  float64
+	peak    timeBucket
+	// burstiness goes from -1 (regular) through 0 (random) to 1 (bursty)
+	burstiness float64
+}
+
+func newTimeline(bucketMs int64) *timeline {
+	return &timeline{bucketMs: bucketMs, counts: make(map[int64]int)}
+}
+
+// parseBucket reads the size of the buckets, second|minute|hour or a
+// duration (ie: 5m, 15s)
+func parseBucket(raw string) (int64, error) {
+	var d time.Duration
+	switch strings.ToLower(raw) {
+	case "":
+		return 0, nil
+	case "second", "s":
+		d = time.Second
+		break
+	case "minute", "m":
+		d = time.Minute
+		break
+	case "hour", "h":
+		d = time.Hour
+		break
+	default:
+		var err error
+		d, err = time.ParseDuration(raw)
+		if err != nil {
+			return 0, errors.New("bucket not supported: " + raw)
+		}
+	}
+
+	if d < time.Millisecond {
+		return 0, errors.New("bucket has to be at least 1ms: " + raw)
+	}
+
+	return d.Milliseconds(), nil
+}
+
+// add counts a request at its time, requests without one are counted apart
+func (t *timeline) add(unixMs int64) {
+	if unixMs <= 0 {
+		t.untimed += 1
+		return
+	}
+
+	t.counts[unixMs-unixMs%t.bucketMs] += 1
+}
+
+// merge adds the requests of another timeline with the same buckets
+func (t *timeline) merge(other *timeline) {
+	t.untimed += other.untimed
+	for k, c := range other.counts {
+		t.counts[k] += c
+	}
+}
+
+// bounds returns the first and last buckets
+func (t *timeline) bounds() (int64, int64, bool) {
+	first, last := int64(0), int64(0)
+	for k := range t.counts {
+		if first == 0 || k < first {
+			first = k
+		}
+		if k > last {
+			last = k
+		}
+	}
+
+	return first, last, len(t.counts) > 0
+}
+
+// timelineWiderBuckets are the buckets tried, in order, when the one asked
+// for is too small for the time of the requests
+var timelineWiderBuckets = []time.Duration{
+	time.Second,
+	15 * time.Second,
+	time.Minute,
+	15 * time.Minute,
+	time.Hour,
+	6 * time.Hour,
+	24 * time.Hour,
+	7 * 24 * time.Hour,
+}
+
+// fitBucket returns the bucket to use between the first and last buckets, a
+// multiple of the bucket so there are timelineMaxBuckets at most
+func fitBucket(bucketMs int64, firstMs int64, lastMs int64) int64 {
+	fits := func(size int64) bool {
+		return (lastMs-firstMs)/size+1 <= timelineMaxBuckets
+	}
+	if fits(bucketMs) {
+		return bucketMs
+	}
+
+	for _, d := range timelineWiderBuckets {
+		size := d.Milliseconds()
+		if size%bucketMs == 0 && fits(size) {
+			return size
+		}
+	}
+
+	buckets := (lastMs-firstMs)/bucketMs + 1
+	return bucketMs * ((buckets + timelineMaxBuckets - 1) / timelineMaxBuckets)
+}
+
+// regroup returns the timeline on a wider bucket, a multiple of its own
+func (t *timeline) regroup(bucketMs int64) *timeline {
+	if t == nil || bucketMs == t.bucketMs {
+		return t
+	}
+
+	regrouped := newTimeline(bucketMs)
+	regrouped.untimed = t.untimed
+	for k, c := range t.counts {
+		regrouped.counts[k-k%bucketMs] += c
+	}
+
+	return regrouped
+}
+
+// series returns the buckets with requests from the first to the last, the
+// empty ones are left out
+func (t *timeline) series(firstMs int64, lastMs int64) []timeBucket {
+	series := []timeBucket{}
+	for k, c := range t.counts {
+		if k >= firstMs && k <= lastMs {
+			series = append(series, timeBucket{startMs: k, count: c})
+		}
+	}
+
+	sort.Slice(series, func(i, j int) bool { return series[i].startMs < series[j].startMs })
+
+	return series
+}
+
+// bucketsBetween is the number of buckets from the first to the last, the
+// empty ones too
+func bucketsBetween(firstMs int64, lastMs int64, bucketMs int64) int {
+	if firstMs == 0 {
+		return 0
+	}
+
+	return int((lastMs-firstMs)/bucketMs) + 1
+}
+
+// summarizeRate finds the mean, peak and burstiness of the series, the
+// buckets missing from the series count as empty ones
+func summarizeRate(series []timeBucket, buckets int) rateSummary {
+	summary := rateSummary{buckets: buckets}
+	if buckets == 0 {
+		return summary
+	}
+
+	for _, b := range series {
+		summary.total += b.count
+		if b.count > summary.peak.count {
+			summary.peak = b
+		}
+	}
+	summary.mean = float64(summary.total) / float64(buckets)
+
+	variance := float64(buckets-len(series)) * math.Pow(summary.mean, 2)
+	for _, b := range series {
+		variance += math.Pow(float64(b.count)-summary.mean, 2)
+	}
+	summary.stddev = math.Sqrt(variance / float64(buckets))
+
+	if summary.stddev+summary.mean > 0 {
+		summary.burstiness = (summary.stddev - summary.mean) / (summary.stddev + summary.mean)
+	}
+
+	return summary
+}
+
+// peakWindows returns the buckets with the most requests, the earliest first
+// on ties
+func peakWindows(series []timeBucket, limit int) []timeBucket {
+	peaks := append([]timeBucket{}, series...)
+
+	sort.SliceStable(peaks, func(i, j int) bool { return peaks[i].count > peaks[j].count })
+
+	if len(peaks) > limit {
+		peaks = peaks[:limit]
+	}
+
+	return peaks
+}
+
+// perSecond converts a count per bucket to a count per second
+func perSecond(count float64, bucketMs int64) float64 {
+	return count * 1000 / float64(bucketMs)
+}
+
+// bucketLabel returns the size of the buckets, ie: 1m, 15s
+func bucketLabel(bucketMs int64) string {
+	label := (time.Duration(bucketMs) * time.Millisecond).String()
+	if strings.HasSuffix(label, "m0s") {
+		label = strings.TrimSuffix(label, "0s")
+	}
+	if strings.HasSuffix(label, "h0m") {
+		label = strings.TrimSuffix(label, "0m")
+	}
+
+	return label
+}
+
+// bucketTime returns the time of a bucket, in utc
+func bucketTime(startMs int64, bucketMs int64) string {
+	layout := "2006-01-02 15:04:05"
+	if bucketMs%1000 != 0 {
+		layout += ".000"
+	}
+
+	return time.UnixMilli(startMs).UTC().Format(layout)
+}
+
+// chartLines draws the timeline as an ascii bar chart, a row per bucket from
+// the first to the last, the empty ones are drawn too
+func chartLines(t *timeline, firstMs int64, lastMs int64) []string {
+	// consecutive buckets are summed so the chart keeps a readable height
+	buckets := bucketsBetween(firstMs, lastMs, t.bucketMs)
+	size := (buckets + timelineChartRows - 1) / timelineChartRows
+	if size < 1 {
+		size = 1
+	}
+	bucketMs := t.bucketMs
+
+	rows := []timeBucket{}
+	for i := 0; i < buckets; i += size {
+		rows = append(rows, timeBucket{startMs: firstMs + int64(i)*bucketMs})
+	}
+	for _, b := range t.series(firstMs, lastMs) {
+		rows[int((b.startMs-firstMs)/bucketMs)/size].count += b.count
+	}
+
+	max := 0
+	for _, r := range rows {
+		if r.count > max {
+			max = r.count
+		}
+	}
+
+	lines := []string{}
+	if size > 1 {
+		lines = append(lines, fmt.Sprintf("(a row per %s)", bucketLabel(bucketMs*int64(size))))
+	}
+
+	for _, r := range rows {
+		width := 0
+		if max > 0 {
+			width = int(math.Round(float64(r.count) * timelineChartWidth / float64(max)))
+		}
+
+		lines = append(lines, fmt.Sprintf(
+			"%s | %-*s %d",
+			bucketTime(r.startMs, bucketMs),
+			timelineChartWidth,
+			strings.Repeat("#", width),
+			r.count,
+		))
+	}
+
+	return lines
+}